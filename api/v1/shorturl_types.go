@@ -5,30 +5,171 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Namespace annotations enforced by the ShortURL validating webhook
+const (
+	// AnnotationAllowedTargetHosts restricts target URLs in the namespace to a comma-separated
+	// list of hosts, where "*.example.com" matches any subdomain of example.com
+	AnnotationAllowedTargetHosts = "urlshortener.tapsi.ir/allowed-target-hosts"
+	// AnnotationAllowCustomPaths set to "false" forbids spec.customPath in the namespace
+	AnnotationAllowCustomPaths = "urlshortener.tapsi.ir/allow-custom-paths"
+)
+
+// AnnotationPathMetrics set to "true" on a ShortURL labels its redirect metrics with the short path.
+// Every labelled path adds a time series, so it is off by default.
+const AnnotationPathMetrics = "urlshortener.tapsi.ir/path-metrics"
+
+// ShortURLPhase is the lifecycle phase of a ShortURL
+type ShortURLPhase string
+
+const (
+	// PhaseActive means the short path is claimed and redirecting
+	PhaseActive ShortURLPhase = "Active"
+	// PhaseConflict means the requested short path is owned by another ShortURL
+	PhaseConflict ShortURLPhase = "Conflict"
+	// PhaseExpired means the link has passed its expiration time and answers 410 Gone
+	PhaseExpired ShortURLPhase = "Expired"
+)
+
+// Condition types reported in ShortURLStatus.Conditions
+const (
+	// ConditionReady is True when the short path is serving redirects to the target URL
+	ConditionReady = "Ready"
+	// ConditionTargetValid is True when spec.targetURL is an absolute http(s) URL
+	ConditionTargetValid = "TargetValid"
+	// ConditionPathConflict is True when the requested short path is owned by another ShortURL, or
+	// when a stable path can't follow the spec because it is shared with other ShortURLs
+	ConditionPathConflict = "PathConflict"
+	// ConditionStorageSynced is True when the mapping in Redis matches the spec
+	ConditionStorageSynced = "StorageSynced"
+)
+
+// Condition reasons reported in ShortURLStatus.Conditions
+const (
+	ReasonActive           = "Active"
+	ReasonExpired          = "Expired"
+	ReasonValidURL         = "ValidURL"
+	ReasonInvalidURL       = "InvalidURL"
+	ReasonNoConflict       = "NoConflict"
+	ReasonPathOwnedByOther = "PathOwnedByOther"
+	ReasonPathShared       = "PathShared"
+	ReasonSynced           = "Synced"
+	ReasonRedisError       = "RedisError"
+)
+
+// PathStrategy records how the short path of a ShortURL was chosen
+type PathStrategy string
+
+const (
+	// PathStrategyCustom means the path came from spec.customPath
+	PathStrategyCustom PathStrategy = "Custom"
+	// PathStrategyHash means the path is the default-length prefix of the target URL hash
+	PathStrategyHash PathStrategy = "Hash"
+	// PathStrategyExtendedHash means the default prefix collided and a longer prefix of the hash was used
+	PathStrategyExtendedHash PathStrategy = "ExtendedHash"
+)
+
+// PathPolicy controls what happens to a generated short path when the target URL changes
+// +kubebuilder:validation:Enum=Stable;Rehash
+type PathPolicy string
+
+const (
+	// PathPolicyStable keeps the short path and its click history and retargets it in place
+	PathPolicyStable PathPolicy = "Stable"
+	// PathPolicyRehash moves the link to the short path derived from the new target URL
+	PathPolicyRehash PathPolicy = "Rehash"
+)
+
 // ShortURLSpec defines the desired state of ShortURL
+// +kubebuilder:validation:XValidation:rule="!(has(self.expiresAt) && has(self.ttl))",message="expiresAt and ttl are mutually exclusive"
 type ShortURLSpec struct {
 	// TargetURL is the original URL to be shortened
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Format=url
 	TargetURL string `json:"targetURL"`
+
+	// CustomPath is an optional vanity short path (e.g. /standup) used instead of the generated hash
+	// +optional
+	// +kubebuilder:validation:Pattern=^/[a-zA-Z0-9]+$
+	// +kubebuilder:validation:MaxLength=64
+	CustomPath string `json:"customPath,omitempty"`
+
+	// ExpiresAt is an absolute time after which the link stops redirecting
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// TTL is the lifetime of the link counted from the creation of the ShortURL
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// RedirectType is the HTTP status code used to redirect, defaulting to the server-wide default (302)
+	// +optional
+	// +kubebuilder:validation:Enum=301;302;307;308
+	RedirectType int32 `json:"redirectType,omitempty"`
+
+	// PathPolicy controls whether a generated short path survives changes to TargetURL.
+	// Custom paths always do.
+	// +optional
+	// +kubebuilder:default=Rehash
+	PathPolicy PathPolicy `json:"pathPolicy,omitempty"`
 }
 
 // ShortURLStatus defines the observed state of ShortURL
 type ShortURLStatus struct {
 	// ShortPath is the generated short path
-	// +kubebuilder:validation:Pattern=^/[a-zA-Z0-9]+$
+	// +kubebuilder:validation:Pattern=^/[a-zA-Z0-9_-]+$
 	ShortPath string `json:"shortPath,omitempty"`
 
+	// PathStrategy is how ShortPath was chosen, including hash collision fallback
+	// +kubebuilder:validation:Enum=Custom;Hash;ExtendedHash
+	PathStrategy PathStrategy `json:"pathStrategy,omitempty"`
+
 	// ClickCount is the number of times the short URL has been accessed
 	// +kubebuilder:validation:Minimum=0
 	ClickCount int64 `json:"clickCount,omitempty"`
+
+	// ClicksLast24h is the number of clicks in the current hour and the 23 hours before it
+	// +kubebuilder:validation:Minimum=0
+	ClicksLast24h int64 `json:"clicksLast24h,omitempty"`
+
+	// ClicksLast7d is the number of clicks in the current day (UTC) and the 6 days before it
+	// +kubebuilder:validation:Minimum=0
+	ClicksLast7d int64 `json:"clicksLast7d,omitempty"`
+
+	// UniqueVisitors is the approximate number of distinct clients, told apart by address and user agent
+	// +kubebuilder:validation:Minimum=0
+	UniqueVisitors int64 `json:"uniqueVisitors,omitempty"`
+
+	// Phase is the current lifecycle phase of the short URL
+	// +kubebuilder:validation:Enum=Active;Conflict;Expired
+	Phase ShortURLPhase `json:"phase,omitempty"`
+
+	// ExpiresAt is the effective expiration time applied to the short path
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// SharedWith lists the other ShortURLs (namespace/name) resolving to the same short path
+	// +optional
+	SharedWith []string `json:"sharedWith,omitempty"`
+
+	// ObservedGeneration is the most recent generation observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest observations of the ShortURL state
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Target URL",type=string,JSONPath=`.spec.targetURL`
 // +kubebuilder:printcolumn:name="Short Path",type=string,JSONPath=`.status.shortPath`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
 // +kubebuilder:printcolumn:name="Clicks",type=integer,JSONPath=`.status.clickCount`
+// +kubebuilder:printcolumn:name="Clicks 24h",type=integer,JSONPath=`.status.clicksLast24h`,priority=1
+// +kubebuilder:printcolumn:name="Clicks 7d",type=integer,JSONPath=`.status.clicksLast7d`,priority=1
+// +kubebuilder:printcolumn:name="Visitors",type=integer,JSONPath=`.status.uniqueVisitors`,priority=1
 
 // ShortURL is the Schema for the shorturls API
 type ShortURL struct {
@@ -17,10 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,6 +34,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
@@ -40,6 +47,11 @@ import (
 	urlshortenerv1 "github.com/abexamir/url-shortener-operator/api/v1"
 	constants "github.com/abexamir/url-shortener-operator/internal/constants"
 	controller "github.com/abexamir/url-shortener-operator/internal/controller"
+	"github.com/abexamir/url-shortener-operator/internal/service/events"
+	httpserver "github.com/abexamir/url-shortener-operator/internal/service/httpserver"
+	redisHandler "github.com/abexamir/url-shortener-operator/internal/service/redis"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+	webhookurlshortenerv1 "github.com/abexamir/url-shortener-operator/internal/webhook/v1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -48,6 +60,13 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// Components run by the manager, selected with --mode
+const (
+	modeAll        = "all"
+	modeController = "controller"
+	modeRedirector = "redirector"
+)
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
@@ -64,6 +83,17 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var storageBackend string
+	var mode string
+	var degradedMode bool
+	var resyncInterval time.Duration
+	var resyncDryRun bool
+	var geoIPDatabase string
+	var statsTokenFile string
+	var eventSinks string
+	var eventSinkOpts eventSinkOptions
+	var eventOptions events.PublisherOptions
+	var redirectOptions httpserver.Options
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -82,6 +112,69 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&storageBackend, "storage-backend", "redis",
+		"Where short URLs are stored, either redis or memory. The memory backend is not shared between replicas "+
+			"and loses its state on restart, use it for local development only.")
+	flag.StringVar(&mode, "mode", modeAll,
+		"Which components to run: controller (reconciler and webhook), redirector (redirect server only) or all. "+
+			"Redirectors don't need leader election nor write access to the cluster, so they can be scaled independently.")
+	flag.StringVar(&redirectOptions.BindAddress, "redirect-bind-address", ":8082",
+		"The address the redirect server binds to.")
+	flag.DurationVar(&redirectOptions.ReadTimeout, "redirect-read-timeout", 5*time.Second,
+		"Maximum duration for reading a redirect request, including its body.")
+	flag.DurationVar(&redirectOptions.WriteTimeout, "redirect-write-timeout", 10*time.Second,
+		"Maximum duration before timing out the response of a redirect request.")
+	flag.DurationVar(&redirectOptions.IdleTimeout, "redirect-idle-timeout", 120*time.Second,
+		"Maximum time to wait for the next request on a keep-alive connection of the redirect server.")
+	flag.DurationVar(&redirectOptions.ShutdownTimeout, "redirect-shutdown-timeout", 5*time.Second,
+		"Maximum time to wait for in-flight redirect requests to finish on shutdown.")
+	flag.IntVar(&redirectOptions.CacheSize, "redirect-cache-size", 10000,
+		"Number of short paths the redirect server caches in memory, 0 disables the cache.")
+	flag.DurationVar(&redirectOptions.CacheTTL, "redirect-cache-ttl", time.Minute,
+		"Maximum time a link is served from the cache. Changes are normally picked up right away through Redis pub/sub.")
+	flag.DurationVar(&redirectOptions.NegativeCacheTTL, "redirect-negative-cache-ttl", 5*time.Second,
+		"Maximum time an unknown or expired short path is cached.")
+	flag.BoolVar(&degradedMode, "degraded-mode", true,
+		"If set, redirects are resolved from the ShortURL resources while storage is unavailable, and clicks are "+
+			"buffered until it is back. Needs read access to ShortURLs.")
+	flag.IntVar(&redirectOptions.ClickQueueSize, "click-queue-size", 10000,
+		"Number of clicks that may wait to be counted. Clicks beyond that are dropped instead of slowing redirects down.")
+	flag.DurationVar(&redirectOptions.ClickFlushInterval, "click-flush-interval", time.Second,
+		"How often the clicks aggregated per short path are written to storage.")
+	flag.StringVar(&geoIPDatabase, "geoip-database", "",
+		"Path to a MaxMind DB file (e.g. GeoLite2-Country.mmdb) to break clicks down by country. "+
+			"Countries are left out if unset.")
+	flag.StringVar(&statsTokenFile, "stats-api-token-file", "",
+		"File with the bearer tokens accepted by the statistics API under /_api/, one per line. "+
+			"The API is disabled if unset.")
+	flag.BoolVar(&redirectOptions.ClickStream, "click-stream", false,
+		"If set, every click is appended as an event to a capped Redis Stream for other consumers. "+
+			"Needs the redis storage backend.")
+	flag.BoolVar(&redirectOptions.TrustForwardedFor, "redirect-trust-forwarded-for", false,
+		"If set, client addresses are taken from the X-Forwarded-For header. Only enable it behind a proxy that "+
+			"sets the header, clients could spoof it otherwise.")
+	flag.StringVar(&eventSinks, "event-sinks", "",
+		"Comma separated sinks receiving click and ShortURL lifecycle events: stdout, file and http. "+
+			"No events are published if unset.")
+	flag.StringVar(&eventSinkOpts.file, "event-file", "events.jsonl",
+		"File the file event sink appends JSON lines to.")
+	flag.Int64Var(&eventSinkOpts.fileMaxSize, "event-file-max-size", 100<<20,
+		"Size in bytes past which the event file is rotated.")
+	flag.IntVar(&eventSinkOpts.fileMaxBackups, "event-file-max-backups", 5,
+		"Number of rotated event files to keep.")
+	flag.StringVar(&eventSinkOpts.httpURL, "event-http-url", "",
+		"URL the http event sink POSTs batches of CloudEvents to.")
+	flag.IntVar(&eventOptions.QueueSize, "event-queue-size", 10000,
+		"Number of events that may wait per sink. Events beyond that are dropped.")
+	flag.IntVar(&eventOptions.BatchSize, "event-batch-size", 100,
+		"Number of events sent to a sink at once.")
+	flag.DurationVar(&eventOptions.FlushInterval, "event-flush-interval", time.Second,
+		"How long events wait at most for their batch to fill up.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 10*time.Minute,
+		"How often the controller compares storage with the ShortURLs, rebuilding missing short paths and "+
+			"removing orphaned ones. The first sweep runs at startup, 0 disables it.")
+	flag.BoolVar(&resyncDryRun, "resync-dry-run", false,
+		"If set, the resync only logs what it would rebuild or remove.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -90,6 +183,25 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if mode != modeAll && mode != modeController && mode != modeRedirector {
+		setupLog.Error(nil, "unknown mode", "mode", mode)
+		os.Exit(1)
+	}
+	if redirectOptions.ClickFlushInterval <= 0 {
+		setupLog.Error(nil, "--click-flush-interval must be positive")
+		os.Exit(1)
+	}
+	if redirectOptions.ClickQueueSize < 1 {
+		setupLog.Error(nil, "--click-queue-size must be at least 1")
+		os.Exit(1)
+	}
+	runController := mode != modeRedirector
+	runRedirector := mode != modeController
+	if !runController && enableLeaderElection {
+		setupLog.Info("Ignoring --leader-elect, redirectors don't need leader election")
+		enableLeaderElection = false
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -203,13 +315,89 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controller.ShortURLReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ShortURL")
+	if statsTokenFile != "" {
+		if redirectOptions.StatsTokens, err = httpserver.LoadStatsTokens(statsTokenFile); err != nil {
+			setupLog.Error(err, "unable to load statistics API tokens", "path", statsTokenFile)
+			os.Exit(1)
+		}
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	// Without the controller, degraded mode can serve redirects before Redis is reachable
+	store, err := newStorage(ctx, storageBackend, !runController && degradedMode)
+	if err != nil {
+		setupLog.Error(err, "unable to set up storage", "backend", storageBackend)
+		os.Exit(1)
+	}
+	if _, ok := store.(storage.ClickEventStream); redirectOptions.ClickStream && !ok {
+		setupLog.Error(nil, "--click-stream is not supported by the storage backend", "backend", storageBackend)
 		os.Exit(1)
 	}
+
+	var publisher *events.Publisher
+	if eventSinks != "" {
+		sinks, err := newEventSinks(strings.Split(eventSinks, ","), eventSinkOpts)
+		if err != nil {
+			setupLog.Error(err, "unable to set up event sinks", "sinks", eventSinks)
+			os.Exit(1)
+		}
+		publisher = events.NewPublisher(eventOptions, sinks...)
+	}
+
+	if runController {
+		reconciler := &controller.ShortURLReconciler{
+			Client:  mgr.GetClient(),
+			Scheme:  mgr.GetScheme(),
+			Storage: store,
+			Events:  publisher,
+		}
+		if resyncInterval > 0 {
+			resyncer := controller.NewResyncer(mgr.GetClient(), mgr.GetAPIReader(), store, resyncInterval, resyncDryRun)
+			reconciler.ResyncEvents = resyncer.Events()
+			if err := mgr.Add(resyncer); err != nil {
+				setupLog.Error(err, "unable to add resync to manager")
+				os.Exit(1)
+			}
+		}
+		if err = reconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ShortURL")
+			os.Exit(1)
+		}
+	}
+	var redirectServer *httpserver.RedirectServer
+	if runRedirector {
+		redirectServer = httpserver.NewRedirectServer(store, redirectOptions)
+		if degradedMode {
+			index, err := httpserver.NewShortURLIndex(ctx, mgr)
+			if err != nil {
+				setupLog.Error(err, "unable to set up ShortURL index for degraded mode")
+				os.Exit(1)
+			}
+			redirectServer.WithFallback(index)
+		}
+		if geoIPDatabase != "" {
+			geoIP, err := httpserver.OpenGeoIP(geoIPDatabase)
+			if err != nil {
+				setupLog.Error(err, "unable to open GeoIP database", "path", geoIPDatabase)
+				os.Exit(1)
+			}
+			redirectServer.WithGeoIP(geoIP)
+		}
+		if publisher != nil {
+			redirectServer.WithEvents(publisher)
+		}
+		if err := mgr.Add(redirectServer); err != nil {
+			setupLog.Error(err, "unable to add redirect server to manager")
+			os.Exit(1)
+		}
+	}
+	// nolint:goconst
+	if runController && os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookurlshortenerv1.SetupShortURLWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ShortURL")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if metricsCertWatcher != nil {
@@ -236,18 +424,156 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	// The controller can't reconcile without storage. A pod that only serves redirects still can in
+	// degraded mode, so it stays in the Service endpoints. Liveness doesn't depend on storage,
+	// restarting wouldn't bring Redis back.
+	if runController || !degradedMode {
+		if err := mgr.AddReadyzCheck("storage", storageCheck(store)); err != nil {
+			setupLog.Error(err, "unable to set up storage ready check")
+			os.Exit(1)
+		}
+	}
+	if redirectServer != nil {
+		if err := mgr.AddReadyzCheck("redirect-server", redirectServer.ReadyCheck); err != nil {
+			setupLog.Error(err, "unable to set up redirect server ready check")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	stopPublisher := func() {}
+	if publisher != nil {
+		stopPublisher = startPublisher(publisher)
+	}
+	err = mgr.Start(ctx)
+	// The redirect server publishes the clicks of its final flush while the manager shuts down
+	stopPublisher()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+}
 
-	if err = (&controller.ShortURLReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ShortURL")
-		os.Exit(1)
+// startPublisher runs publisher outside of the manager, which would stop it together with the
+// runnables that still publish while they shut down. The returned function stops it and waits
+// for the queued events to be delivered.
+func startPublisher(publisher *events.Publisher) (stop func()) {
+	ctx, cancel := context.WithCancel(ctrl.LoggerInto(context.Background(), ctrl.Log.WithName("events")))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = publisher.Start(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// redisConnectBackoff retries the initial connection to Redis for about two minutes
+var redisConnectBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    8,
+	Cap:      30 * time.Second,
+}
+
+// newStorage returns the storage backend selected by name
+// newStorage returns the storage backend. Redis is waited for with redisConnectBackoff, unless
+// degraded is set: then the client keeps connecting in the background.
+func newStorage(ctx context.Context, backend string, degraded bool) (storage.Storage, error) {
+	switch backend {
+	case "memory":
+		setupLog.Info("Using in-memory storage")
+		return storage.NewMemoryStorage(), nil
+	case "redis":
+		opts := redisHandler.OptionsFromEnv()
+		setupLog.Info("Initializing Redis client", "mode", opts.Mode, "addrs", opts.Addrs, "tls", opts.TLS != nil)
+
+		if degraded {
+			redisService, err := redisHandler.NewRedisService(opts)
+			if !errors.Is(err, redisHandler.ErrUnreachable) {
+				return redisService, err
+			}
+			setupLog.Info("Redis is unreachable, serving redirects in degraded mode until it is back", "error", err.Error())
+			redisService, err = redisHandler.NewLazyRedisService(opts)
+			if err != nil {
+				return nil, err
+			}
+			go waitForRedis(ctx, redisService)
+			return redisService, nil
+		}
+
+		// Wait for Redis to be ready, but give up eventually so a lasting outage shows up as a
+		// crash looping pod instead of one that hangs silently
+		var redisService *redisHandler.RedisService
+		err := wait.ExponentialBackoffWithContext(ctx, redisConnectBackoff, func(context.Context) (bool, error) {
+			var err error
+			redisService, err = redisHandler.NewRedisService(opts)
+			if errors.Is(err, redisHandler.ErrUnreachable) {
+				setupLog.Info("Waiting for Redis to be ready...", "error", err.Error())
+				return false, nil
+			}
+			return err == nil, err
+		})
+		if wait.Interrupted(err) {
+			return nil, fmt.Errorf("gave up waiting for Redis: %w", err)
+		}
+		return redisService, err
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// waitForRedis logs once Redis answers. The client reconnects by itself, this only reports it.
+func waitForRedis(ctx context.Context, redisService *redisHandler.RedisService) {
+	err := wait.PollUntilContextCancel(ctx, 10*time.Second, false, func(ctx context.Context) (bool, error) {
+		return redisService.Ping(ctx) == nil, nil
+	})
+	if err == nil {
+		setupLog.Info("Connected to Redis, leaving degraded mode")
+	}
+}
+
+// eventSinkOptions configure the event sinks selected with --event-sinks
+type eventSinkOptions struct {
+	file           string
+	fileMaxSize    int64
+	fileMaxBackups int
+	httpURL        string
+}
+
+// newEventSinks returns the event sinks selected by name
+func newEventSinks(names []string, opts eventSinkOptions) ([]events.Sink, error) {
+	var sinks []events.Sink
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "stdout":
+			sinks = append(sinks, events.NewStdoutSink())
+		case "file":
+			sink, err := events.NewFileSink(opts.file, opts.fileMaxSize, opts.fileMaxBackups)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "http":
+			if opts.httpURL == "" {
+				return nil, errors.New("the http event sink needs --event-http-url")
+			}
+			sinks = append(sinks, events.NewHTTPSink(opts.httpURL, nil))
+		default:
+			return nil, fmt.Errorf("unknown event sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// storageCheck reports whether the storage backend answers in time
+func storageCheck(store storage.Storage) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), 2*time.Second)
+		defer cancel()
+		return store.Ping(ctx)
 	}
 }
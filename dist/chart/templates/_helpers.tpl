@@ -48,3 +48,51 @@ app.kubernetes.io/instance: {{ .Release.Name }}
     $hasValidating = true }}{{- end }}
 {{- end }}
 {{ $hasValidating }}}}{{- end }}
+
+
+{{/* Environment of the containers that talk to Redis */}}
+{{- define "chart.redisEnv" -}}
+- name: REDIS_MODE
+  value: {{ .Values.redis.mode | quote }}
+{{- with .Values.redis.addrs }}
+- name: REDIS_ADDRS
+  value: {{ join "," . | quote }}
+{{- end }}
+{{- if eq .Values.redis.mode "sentinel" }}
+- name: REDIS_SENTINEL_MASTER
+  value: {{ .Values.redis.sentinelMaster | quote }}
+{{- end }}
+- name: REDIS_DB
+  value: {{ .Values.redis.db | quote }}
+{{- with .Values.redis.auth.existingSecret }}
+- name: REDIS_USERNAME
+  valueFrom:
+    secretKeyRef:
+      name: {{ . }}
+      key: {{ $.Values.redis.auth.usernameKey }}
+      optional: true
+- name: REDIS_PASSWORD
+  valueFrom:
+    secretKeyRef:
+      name: {{ . }}
+      key: {{ $.Values.redis.auth.passwordKey }}
+{{- end }}
+{{- if .Values.redis.tls.enable }}
+- name: REDIS_TLS_ENABLED
+  value: "true"
+{{- with .Values.redis.tls.serverName }}
+- name: REDIS_TLS_SERVER_NAME
+  value: {{ . | quote }}
+{{- end }}
+{{- if .Values.redis.tls.existingSecret }}
+- name: REDIS_TLS_CA_FILE
+  value: /etc/redis-tls/ca.crt
+{{- if .Values.redis.tls.clientCertificate }}
+- name: REDIS_TLS_CERT_FILE
+  value: /etc/redis-tls/tls.crt
+- name: REDIS_TLS_KEY_FILE
+  value: /etc/redis-tls/tls.key
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
@@ -4,19 +4,57 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 var (
 	// Redis related constants
-	RedisServiceHost    = getEnvOrDefault("REDIS_SERVICE_HOST", "urlshortener-redis")
-	RedisServicePort    = getEnvOrDefault("REDIS_SERVICE_PORT", "6379")
-	RedisServiceAddr    = fmt.Sprintf("%s:%s", RedisServiceHost, RedisServicePort)
-	ClickCountKeyPrefix = getEnvOrDefault("CLICK_COUNT_KEY_PREFIX", "clicks:")
+	RedisServiceHost      = getEnvOrDefault("REDIS_SERVICE_HOST", "urlshortener-redis")
+	RedisServicePort      = getEnvOrDefault("REDIS_SERVICE_PORT", "6379")
+	RedisServiceAddr      = fmt.Sprintf("%s:%s", RedisServiceHost, RedisServicePort)
+	ClickCountKeyPrefix   = getEnvOrDefault("CLICK_COUNT_KEY_PREFIX", "clicks:")
+	OwnerKeyPrefix        = getEnvOrDefault("OWNER_KEY_PREFIX", "owner:")
+	ExpiredKeyPrefix      = getEnvOrDefault("EXPIRED_KEY_PREFIX", "expired:")
+	RedirectTypeKeyPrefix = getEnvOrDefault("REDIRECT_TYPE_KEY_PREFIX", "redirect:")
+	PathMetricsKeyPrefix  = getEnvOrDefault("PATH_METRICS_KEY_PREFIX", "pathmetrics:")
+	ClickSeriesKeyPrefix  = getEnvOrDefault("CLICK_SERIES_KEY_PREFIX", "series:")
+	VisitorsKeyPrefix     = getEnvOrDefault("VISITORS_KEY_PREFIX", "visitors:")
+	BreakdownKeyPrefix    = getEnvOrDefault("BREAKDOWN_KEY_PREFIX", "breakdown:")
+	BreakdownMaxValues    = getIntEnvOrDefault("BREAKDOWN_MAX_VALUES", 1000)                   // distinct values per breakdown, more count as Other
+	InvalidationChannel   = getEnvOrDefault("INVALIDATION_CHANNEL", "urlshortener:invalidate") // pub/sub channel announcing changed short paths
+	ExpiredLinkRetention  = getIntEnvOrDefault("EXPIRED_LINK_RETENTION", 7*24*60*60)           // seconds to answer 410 Gone after expiry
+	ClickHourlyRetention  = getIntEnvOrDefault("CLICK_HOURLY_RETENTION", 7*24)                 // hours of hourly click counts to keep
+	ClickDailyRetention   = getIntEnvOrDefault("CLICK_DAILY_RETENTION", 90)                    // days of daily click counts to keep
+	ClickStreamKey        = getEnvOrDefault("CLICK_STREAM_KEY", "urlshortener:click-events")   // stream of click events
+	ClickStreamMaxLen     = getIntEnvOrDefault("CLICK_STREAM_MAX_LEN", 1000000)                // click events to keep at most
+	ClickStreamRetention  = getIntEnvOrDefault("CLICK_STREAM_RETENTION", 7*24*60*60)           // seconds to keep click events, 0 for no limit
+
+	// Redis connection related constants
+	RedisMode                  = getEnvOrDefault("REDIS_MODE", "standalone")                  // standalone, sentinel or cluster
+	RedisAddrs                 = getListEnvOrDefault("REDIS_ADDRS", RedisServiceAddr)         // server, sentinel or cluster seed node addresses
+	RedisSentinelMaster        = getEnvOrDefault("REDIS_SENTINEL_MASTER", "mymaster")         // master name monitored by the sentinels
+	RedisDB                    = getIntEnvOrDefault("REDIS_DB", 0)                            // not supported in cluster mode
+	RedisUsername              = getEnvOrDefault("REDIS_USERNAME", "")                        // ACL user, usually set from a Secret
+	RedisPassword              = getEnvOrDefault("REDIS_PASSWORD", "")                        // usually set from a Secret
+	RedisSentinelUsername      = getEnvOrDefault("REDIS_SENTINEL_USERNAME", "")               // if the sentinels need their own credentials
+	RedisSentinelPassword      = getEnvOrDefault("REDIS_SENTINEL_PASSWORD", "")               // if the sentinels need their own credentials
+	RedisTLSEnabled            = getBoolEnvOrDefault("REDIS_TLS_ENABLED", false)              // connect over TLS
+	RedisTLSCAFile             = getEnvOrDefault("REDIS_TLS_CA_FILE", "")                     // CA bundle to verify the server, system roots if empty
+	RedisTLSCertFile           = getEnvOrDefault("REDIS_TLS_CERT_FILE", "")                   // client certificate for mutual TLS
+	RedisTLSKeyFile            = getEnvOrDefault("REDIS_TLS_KEY_FILE", "")                    // client key for mutual TLS
+	RedisTLSServerName         = getEnvOrDefault("REDIS_TLS_SERVER_NAME", "")                 // overrides the server name to verify
+	RedisTLSInsecureSkipVerify = getBoolEnvOrDefault("REDIS_TLS_INSECURE_SKIP_VERIFY", false) // for testing only
 
 	// Controller related constants
 	ReconcileInterval = getIntEnvOrDefault("RECONCILE_INTERVAL", 30) // seconds
 	ShortPathLength   = getIntEnvOrDefault("SHORT_PATH_LENGTH", 3)   // characters
 	LeaderElectionID  = getEnvOrDefault("LEADER_ELECTION_ID", "shorturl.tapsi.ir")
+
+	// Redirect server related constants
+	DefaultRedirectType = getIntEnvOrDefault("DEFAULT_REDIRECT_TYPE", 302) // HTTP status code for links without spec.redirectType
+
+	// Webhook related constants
+	ReservedPaths = getListEnvOrDefault("RESERVED_PATHS", "/_api") // paths the redirect server serves itself, including their subpaths
 )
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -34,3 +72,22 @@ func getIntEnvOrDefault(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getBoolEnvOrDefault(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getListEnvOrDefault(key, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnvOrDefault(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
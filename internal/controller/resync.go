@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	urlshortenerv1 "github.com/abexamir/url-shortener-operator/api/v1"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+// Resyncer periodically compares storage with the ShortURLs as a whole. It has the reconciler
+// rebuild mappings that went missing from storage, and removes paths and click counters that
+// no ShortURL refers to any more. It runs on the leader only.
+type Resyncer struct {
+	// client reads ShortURLs from the cache
+	client client.Client
+	// apiReader double checks owners missing from the cache before their claims are dropped
+	apiReader client.Reader
+	storage   storage.Storage
+	// interval between two sweeps, the first one runs right at startup
+	interval time.Duration
+	// dryRun only reports what a sweep would do
+	dryRun bool
+
+	events chan event.GenericEvent
+}
+
+// NewResyncer returns a Resyncer. Pass its Events to the ShortURLReconciler to let it trigger reconciles.
+func NewResyncer(c client.Client, apiReader client.Reader, store storage.Storage, interval time.Duration, dryRun bool) *Resyncer {
+	return &Resyncer{
+		client:    c,
+		apiReader: apiReader,
+		storage:   store,
+		interval:  interval,
+		dryRun:    dryRun,
+		events:    make(chan event.GenericEvent),
+	}
+}
+
+// Events delivers the ShortURLs whose storage entries need to be rebuilt
+func (r *Resyncer) Events() <-chan event.GenericEvent {
+	return r.events
+}
+
+// Start sweeps every Interval until ctx is cancelled
+func (r *Resyncer) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("resync")
+	ctx = log.IntoContext(ctx, logger)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.sweep(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error(err, "Resync failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Resyncer) sweep(ctx context.Context) error {
+	log := log.FromContext(ctx).WithValues("dryRun", r.dryRun)
+
+	var shortURLs urlshortenerv1.ShortURLList
+	if err := r.client.List(ctx, &shortURLs); err != nil {
+		return err
+	}
+	referenced := map[string]bool{}
+	owners := map[string]bool{}
+	for _, shortURL := range shortURLs.Items {
+		owners[client.ObjectKeyFromObject(&shortURL).String()] = true
+		if shortURL.Status.ShortPath != "" {
+			referenced[shortURL.Status.ShortPath] = true
+		}
+	}
+
+	rebuilt, err := r.rebuildMissing(ctx, shortURLs.Items)
+	if err != nil {
+		return err
+	}
+
+	shortPaths, err := r.storage.ListShortPaths(ctx)
+	if err != nil {
+		return err
+	}
+	purged, released := 0, 0
+	for _, shortPath := range shortPaths {
+		pathOwners, err := r.storage.GetOwners(ctx, shortPath)
+		if err != nil {
+			return err
+		}
+		var live, stale []string
+		for _, owner := range pathOwners {
+			exists, err := r.ownerExists(ctx, owners, owner)
+			if err != nil {
+				return err
+			}
+			if exists {
+				live = append(live, owner)
+			} else {
+				stale = append(stale, owner)
+			}
+		}
+
+		switch {
+		case len(live) == 0 && !referenced[shortPath]:
+			log.Info("Purging orphaned short path", "path", shortPath, "staleOwners", stale)
+			purged++
+			if !r.dryRun {
+				if err := r.storage.PurgeURL(ctx, shortPath); err != nil {
+					return err
+				}
+			}
+		case len(live) > 0:
+			// Releasing stale owners never removes the mapping while live ones remain. Paths with only
+			// stale owners that are still referenced are left to the reconciler.
+			for _, owner := range stale {
+				log.Info("Releasing short path from deleted owner", "path", shortPath, "owner", owner)
+				released++
+				if !r.dryRun {
+					if err := r.storage.ReleaseURL(ctx, shortPath, owner); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	log.Info("Resync finished", "shortURLs", len(shortURLs.Items), "shortPaths", len(shortPaths),
+		"rebuilt", rebuilt, "purged", purged, "releasedOwners", released)
+	return nil
+}
+
+// rebuildMissing has the reconciler recreate the entries of active ShortURLs that storage lost
+func (r *Resyncer) rebuildMissing(ctx context.Context, shortURLs []urlshortenerv1.ShortURL) (int, error) {
+	log := log.FromContext(ctx).WithValues("dryRun", r.dryRun)
+
+	rebuilt := 0
+	for i := range shortURLs {
+		shortURL := &shortURLs[i]
+		if shortURL.Status.Phase != urlshortenerv1.PhaseActive || shortURL.Status.ShortPath == "" ||
+			!shortURL.DeletionTimestamp.IsZero() {
+			continue
+		}
+		owner := client.ObjectKeyFromObject(shortURL).String()
+		_, err := r.storage.GetLink(ctx, shortURL.Status.ShortPath)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return rebuilt, err
+		}
+		owners, ownersErr := r.storage.GetOwners(ctx, shortURL.Status.ShortPath)
+		if ownersErr != nil {
+			return rebuilt, ownersErr
+		}
+		if err == nil && slices.Contains(owners, owner) {
+			continue
+		}
+
+		log.Info("Rebuilding missing short path", "path", shortURL.Status.ShortPath, "shortURL", owner)
+		rebuilt++
+		if !r.dryRun {
+			select {
+			case r.events <- event.GenericEvent{Object: shortURL}:
+			case <-ctx.Done():
+				return rebuilt, ctx.Err()
+			}
+		}
+	}
+	return rebuilt, nil
+}
+
+// ownerExists checks owner (namespace/name) against the cached ShortURLs and, if it isn't
+// there, against the API server in case the cache is behind
+func (r *Resyncer) ownerExists(ctx context.Context, cached map[string]bool, owner string) (bool, error) {
+	if cached[owner] {
+		return true, nil
+	}
+	namespace, name, ok := strings.Cut(owner, "/")
+	if !ok {
+		return false, nil
+	}
+	err := r.apiReader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &urlshortenerv1.ShortURL{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
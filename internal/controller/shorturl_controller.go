@@ -5,30 +5,49 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"net/url"
+	"slices"
+	"strings"
 	"time"
 
-	"os"
-
-	"github.com/go-redis/redis/v8"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	urlshortenerv1 "github.com/abexamir/url-shortener-operator/api/v1"
 
 	"github.com/abexamir/url-shortener-operator/internal/constants"
-	httpserver "github.com/abexamir/url-shortener-operator/internal/service/httpserver"
-	redisHandler "github.com/abexamir/url-shortener-operator/internal/service/redis"
+	"github.com/abexamir/url-shortener-operator/internal/service/events"
+	"github.com/abexamir/url-shortener-operator/internal/service/metrics"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+	"github.com/abexamir/url-shortener-operator/internal/validation"
 )
 
+// shortURLFinalizer makes sure the Redis entries of a ShortURL are removed before it is deleted
+const shortURLFinalizer = "urlshortener.tapsi.ir/redis-cleanup"
+
+// errPathShared is returned when a stable short path can't follow the spec without moving the other
+// ShortURLs sharing it along
+var errPathShared = errors.New("stable short path is shared")
+
 // ShortURLReconciler reconciles a ShortURL object
 type ShortURLReconciler struct {
 	client.Client
-	Scheme       *runtime.Scheme
-	RedisService *redisHandler.RedisService
+	Scheme  *runtime.Scheme
+	Storage storage.Storage
+	// ResyncEvents optionally delivers ShortURLs to reconcile outside of watch events
+	ResyncEvents <-chan event.GenericEvent
+	// Events optionally receives the lifecycle events of ShortURLs
+	Events *events.Publisher
 }
 
 // +kubebuilder:rbac:groups=urlshortener.tapsi.ir,resources=shorturls,verbs=get;list;watch;create;update;patch;delete
@@ -36,6 +55,14 @@ type ShortURLReconciler struct {
 // +kubebuilder:rbac:groups=urlshortener.tapsi.ir,resources=shorturls/finalizers,verbs=update
 
 func (r *ShortURLReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	result, err := r.reconcile(ctx, req)
+	if err != nil {
+		metrics.ReconcileErrors.Inc()
+	}
+	return result, err
+}
+
+func (r *ShortURLReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	log.Info("Starting Reconcile for ShortURL", "NamespacedName", req.NamespacedName)
 
@@ -44,129 +71,400 @@ func (r *ShortURLReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Validate URL
-	if !r.isValidURL(shortURL.Spec.TargetURL) {
-		log.Error(nil, "Invalid target URL", "url", shortURL.Spec.TargetURL)
-		return ctrl.Result{}, fmt.Errorf("invalid target URL")
-	}
-
 	// Handle deletion
 	if !shortURL.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(shortURL, shortURLFinalizer) {
+			return ctrl.Result{}, nil
+		}
 		if shortURL.Status.ShortPath != "" {
-			if err := r.RedisService.DeleteURL(ctx, shortURL.Status.ShortPath); err != nil {
+			// Only removes the mapping and click counter if no other ShortURL shares the path
+			if err := r.Storage.ReleaseURL(ctx, shortURL.Status.ShortPath, req.NamespacedName.String()); err != nil {
 				log.Error(err, "Failed to delete URL from Redis")
 				return ctrl.Result{}, err
 			}
 		}
+		controllerutil.RemoveFinalizer(shortURL, shortURLFinalizer)
+		if err := r.Update(ctx, shortURL); err != nil {
+			log.Error(err, "Failed to remove finalizer")
+			return ctrl.Result{}, err
+		}
+		r.publish(shortURL, events.TypeShortURLDeleted)
+		return ctrl.Result{}, nil
+	}
+
+	// Make sure Redis is cleaned up before the ShortURL goes away
+	if controllerutil.AddFinalizer(shortURL, shortURLFinalizer) {
+		if err := r.Update(ctx, shortURL); err != nil {
+			log.Error(err, "Failed to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	originalStatus := shortURL.Status.DeepCopy()
+
+	// Validate URL
+	if !validation.IsValidURL(shortURL.Spec.TargetURL) {
+		log.Error(nil, "Invalid target URL", "url", shortURL.Spec.TargetURL)
+		message := fmt.Sprintf("invalid target URL %q", shortURL.Spec.TargetURL)
+		setCondition(shortURL, urlshortenerv1.ConditionTargetValid, metav1.ConditionFalse, urlshortenerv1.ReasonInvalidURL, message)
+		setCondition(shortURL, urlshortenerv1.ConditionReady, metav1.ConditionFalse, urlshortenerv1.ReasonInvalidURL, message)
+		if err := r.updateStatusIfChanged(ctx, shortURL, originalStatus); err != nil {
+			log.Error(err, "Failed to update ShortURL status")
+			return ctrl.Result{}, err
+		}
+		// Retrying won't help, wait for the spec to change
+		return ctrl.Result{}, nil
+	}
+	setCondition(shortURL, urlshortenerv1.ConditionTargetValid, metav1.ConditionTrue, urlshortenerv1.ReasonValidURL, "")
+
+	owner := req.NamespacedName.String()
+
+	// Handle expiration
+	expiresAt := r.expirationTime(shortURL)
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		if shortURL.Status.Phase != urlshortenerv1.PhaseExpired {
+			log.Info("ShortURL has expired", "expiresAt", expiresAt)
+		}
+		// A path shared with longer-lived owners stays, so leave it to them instead of blocking them from
+		// retargeting it. A path of its own expires in storage and keeps answering 410 Gone for a while.
+		if shortURL.Status.ShortPath != "" {
+			owners, err := r.Storage.GetOwners(ctx, shortURL.Status.ShortPath)
+			if err != nil {
+				log.Error(err, "Failed to get short path owners")
+				return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+			}
+			if len(owners) > 1 && slices.Contains(owners, owner) {
+				if err := r.Storage.ReleaseURL(ctx, shortURL.Status.ShortPath, owner); err != nil {
+					log.Error(err, "Failed to release expired short path")
+					return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+				}
+			}
+		}
+		shortURL.Status.Phase = urlshortenerv1.PhaseExpired
+		shortURL.Status.ExpiresAt = expiresAt
+		shortURL.Status.SharedWith = nil
+		setCondition(shortURL, urlshortenerv1.ConditionReady, metav1.ConditionFalse, urlshortenerv1.ReasonExpired,
+			fmt.Sprintf("link expired at %s", expiresAt.UTC().Format(time.RFC3339)))
+		if err := r.updateStatusIfChanged(ctx, shortURL, originalStatus); err != nil {
+			log.Error(err, "Failed to update ShortURL status")
+			return ctrl.Result{}, err
+		}
+		// Redis drops the mapping on its own, nothing left to reconcile until the spec changes
 		return ctrl.Result{}, nil
 	}
 
 	// Handle new resources or updates
 	needsNewShortPath := false
 
-	// For new resources
 	if shortURL.Status.ShortPath == "" {
+		// For new resources
+		needsNewShortPath = true
+	} else if !r.shortPathMatchesSpec(shortURL) {
+		// Target URL or custom path changed
 		needsNewShortPath = true
+		// Clean up old path
+		if err := r.Storage.ReleaseURL(ctx, shortURL.Status.ShortPath, owner); err != nil {
+			log.Error(err, "Failed to delete old Redis entry")
+			return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+		}
 	} else {
-		// For existing resources, check if target URL changed
-		existingURL, err := r.RedisService.GetURL(ctx, shortURL.Status.ShortPath)
-		if err != nil && err != redis.Nil {
+		// For existing resources, make sure the Redis entry is still ours
+		existing, err := r.Storage.GetLink(ctx, shortURL.Status.ShortPath)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
 			log.Error(err, "Failed to get existing URL from Redis")
-			return ctrl.Result{}, err
+			return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+		}
+		owners, ownersErr := r.Storage.GetOwners(ctx, shortURL.Status.ShortPath)
+		if ownersErr != nil {
+			log.Error(ownersErr, "Failed to get short path owners")
+			return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, ownersErr)
 		}
-		// If Redis entry doesn't exist or URL has changed, we need a new short path
-		if err == redis.Nil || existingURL != shortURL.Spec.TargetURL {
+		if errors.Is(err, storage.ErrNotFound) || existing.TargetURL != shortURL.Spec.TargetURL ||
+			existing.RedirectType != int(shortURL.Spec.RedirectType) || slices.Contains(existing.PathMetricsOwners, owner) != pathMetrics(shortURL) ||
+			!shortURL.Status.ExpiresAt.Equal(expiresAt) || !slices.Contains(owners, owner) {
 			needsNewShortPath = true
-			// Clean up old path if it exists
-			if shortURL.Status.ShortPath != "" {
-				if err := r.RedisService.DeleteURL(ctx, shortURL.Status.ShortPath); err != nil {
-					if err == redis.Nil {
-						// Ignore if Redis entry doesn't exist
-						return ctrl.Result{}, nil
-					}
-					log.Error(err, "Failed to delete old Redis entry")
-					return ctrl.Result{}, err
-				}
-			}
 		}
 	}
 	if needsNewShortPath {
-		shortPath := r.generateShortPath(shortURL.Spec.TargetURL)
-
-		if err := r.RedisService.SetURL(ctx, shortPath, shortURL.Spec.TargetURL); err != nil {
+		link := storage.Link{
+			TargetURL:    shortURL.Spec.TargetURL,
+			RedirectType: int(shortURL.Spec.RedirectType),
+			PathMetrics:  pathMetrics(shortURL),
+		}
+		if expiresAt != nil {
+			link.TTL = time.Until(expiresAt.Time)
+		}
+		shortPath, strategy, err := r.claimShortPath(ctx, shortURL, owner, link)
+		if errors.Is(err, storage.ErrPathConflict) {
+			log.Info("Custom path is already taken", "path", shortURL.Spec.CustomPath, "reason", err.Error())
+			shortURL.Status.ShortPath = ""
+			shortURL.Status.PathStrategy = ""
+			shortURL.Status.SharedWith = nil
+			shortURL.Status.Phase = urlshortenerv1.PhaseConflict
+			setCondition(shortURL, urlshortenerv1.ConditionPathConflict, metav1.ConditionTrue, urlshortenerv1.ReasonPathOwnedByOther, err.Error())
+			setCondition(shortURL, urlshortenerv1.ConditionReady, metav1.ConditionFalse, urlshortenerv1.ReasonPathOwnedByOther, err.Error())
+			if err := r.updateStatusIfChanged(ctx, shortURL, originalStatus); err != nil {
+				log.Error(err, "Failed to update ShortURL status")
+				return ctrl.Result{}, err
+			}
+			// Retry later in case the other ShortURL releases the path
+			return ctrl.Result{RequeueAfter: time.Duration(constants.ReconcileInterval) * time.Second}, nil
+		}
+		if errors.Is(err, errPathShared) {
+			// Keep the path, it still serves the link the other owners agreed on
+			log.Info("Stable short path is shared, not retargeting it", "path", shortURL.Status.ShortPath)
+			shortURL.Status.Phase = urlshortenerv1.PhaseConflict
+			setCondition(shortURL, urlshortenerv1.ConditionPathConflict, metav1.ConditionTrue, urlshortenerv1.ReasonPathShared, err.Error())
+			setCondition(shortURL, urlshortenerv1.ConditionReady, metav1.ConditionFalse, urlshortenerv1.ReasonPathShared, err.Error())
+			if err := r.updateStatusIfChanged(ctx, shortURL, originalStatus); err != nil {
+				log.Error(err, "Failed to update ShortURL status")
+				return ctrl.Result{}, err
+			}
+			// Retry later in case the other owners change along or go away
+			return ctrl.Result{RequeueAfter: time.Duration(constants.ReconcileInterval) * time.Second}, nil
+		}
+		if err != nil {
 			log.Error(err, "Failed to set Redis entry")
-			return ctrl.Result{}, err
+			return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
 		}
 
 		shortURL.Status.ShortPath = shortPath
-		if err := r.Status().Update(ctx, shortURL); err != nil {
-			log.Error(err, "Failed to update ShortURL status")
-			return ctrl.Result{}, err
-		}
+		shortURL.Status.PathStrategy = strategy
+		shortURL.Status.ExpiresAt = expiresAt
 	}
 
 	// Update click count
-	clickCount, err := r.RedisService.GetClickCount(ctx, shortURL.Status.ShortPath)
-	if err != nil && err != redis.Nil {
+	clickCount, err := r.Storage.GetClickCount(ctx, shortURL.Status.ShortPath)
+	if err != nil {
 		log.Error(err, "Failed to get click count")
-		return ctrl.Result{}, err
+		return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+	}
+	shortURL.Status.ClickCount = clickCount
+	hourly, err := r.Storage.GetClickSeries(ctx, shortURL.Status.ShortPath, storage.ResolutionHour, 24)
+	if err != nil {
+		log.Error(err, "Failed to get hourly clicks")
+		return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+	}
+	daily, err := r.Storage.GetClickSeries(ctx, shortURL.Status.ShortPath, storage.ResolutionDay, 7)
+	if err != nil {
+		log.Error(err, "Failed to get daily clicks")
+		return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
 	}
+	shortURL.Status.ClicksLast24h = sumClicks(hourly)
+	shortURL.Status.ClicksLast7d = sumClicks(daily)
+	uniqueVisitors, err := r.Storage.GetUniqueVisitors(ctx, shortURL.Status.ShortPath)
+	if err != nil {
+		log.Error(err, "Failed to get unique visitors")
+		return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+	}
+	shortURL.Status.UniqueVisitors = uniqueVisitors
 
-	if clickCount != shortURL.Status.ClickCount {
-		shortURL.Status.ClickCount = clickCount
-		if err := r.Status().Update(ctx, shortURL); err != nil {
-			log.Error(err, "Failed to update click count")
-			return ctrl.Result{}, err
+	// Report other ShortURLs pointing to the same short path
+	owners, err := r.Storage.GetOwners(ctx, shortURL.Status.ShortPath)
+	if err != nil {
+		log.Error(err, "Failed to get short path owners")
+		return r.failWithCondition(ctx, shortURL, urlshortenerv1.ConditionStorageSynced, urlshortenerv1.ReasonRedisError, err)
+	}
+	shortURL.Status.SharedWith = nil
+	for _, other := range owners {
+		if other != owner {
+			shortURL.Status.SharedWith = append(shortURL.Status.SharedWith, other)
 		}
 	}
+	slices.Sort(shortURL.Status.SharedWith)
+
+	shortURL.Status.Phase = urlshortenerv1.PhaseActive
+	setCondition(shortURL, urlshortenerv1.ConditionPathConflict, metav1.ConditionFalse, urlshortenerv1.ReasonNoConflict, "")
+	setCondition(shortURL, urlshortenerv1.ConditionStorageSynced, metav1.ConditionTrue, urlshortenerv1.ReasonSynced, "")
+	setCondition(shortURL, urlshortenerv1.ConditionReady, metav1.ConditionTrue, urlshortenerv1.ReasonActive,
+		fmt.Sprintf("%s redirects to %s", shortURL.Status.ShortPath, shortURL.Spec.TargetURL))
+	if err := r.updateStatusIfChanged(ctx, shortURL, originalStatus); err != nil {
+		log.Error(err, "Failed to update ShortURL status")
+		return ctrl.Result{}, err
+	}
 
-	// Requeue periodically to update click count
-	return ctrl.Result{RequeueAfter: time.Duration(constants.ReconcileInterval) * time.Second}, nil
+	// Requeue periodically to update click count, or right at expiration if that comes first
+	requeueAfter := time.Duration(constants.ReconcileInterval) * time.Second
+	if expiresAt != nil && time.Until(expiresAt.Time) < requeueAfter {
+		requeueAfter = time.Until(expiresAt.Time)
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-func (r *ShortURLReconciler) generateShortPath(url string) string {
-	hash := sha256.Sum256([]byte(url))
-	encoded := base64.URLEncoding.EncodeToString(hash[:])
-	return "/" + encoded[:constants.ShortPathLength]
+// failWithCondition marks condType and Ready as False with the given reason, persists the status
+// and returns err so the request is retried
+func (r *ShortURLReconciler) failWithCondition(ctx context.Context, shortURL *urlshortenerv1.ShortURL, condType, reason string, err error) (ctrl.Result, error) {
+	setCondition(shortURL, condType, metav1.ConditionFalse, reason, err.Error())
+	setCondition(shortURL, urlshortenerv1.ConditionReady, metav1.ConditionFalse, reason, err.Error())
+	shortURL.Status.ObservedGeneration = shortURL.Generation
+	if updateErr := r.Status().Update(ctx, shortURL); updateErr != nil {
+		log.FromContext(ctx).Error(updateErr, "Failed to update ShortURL status")
+	}
+	return ctrl.Result{}, err
 }
 
-func (r *ShortURLReconciler) isValidURL(s string) bool {
-	parsed, err := url.ParseRequestURI(s)
-	if err != nil {
-		return false
+// updateStatusIfChanged stamps the observed generation and writes the status only if it differs from original
+func (r *ShortURLReconciler) updateStatusIfChanged(ctx context.Context, shortURL *urlshortenerv1.ShortURL, original *urlshortenerv1.ShortURLStatus) error {
+	shortURL.Status.ObservedGeneration = shortURL.Generation
+	if equality.Semantic.DeepEqual(original, &shortURL.Status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, shortURL); err != nil {
+		return err
+	}
+	if shortURL.Status.Phase != original.Phase || shortURL.Status.ShortPath != original.ShortPath {
+		switch shortURL.Status.Phase {
+		case urlshortenerv1.PhaseActive:
+			r.publish(shortURL, events.TypeShortURLActivated)
+		case urlshortenerv1.PhaseConflict:
+			r.publish(shortURL, events.TypeShortURLConflicted)
+		case urlshortenerv1.PhaseExpired:
+			r.publish(shortURL, events.TypeShortURLExpired)
+		}
 	}
-	return parsed.Host != "" && (parsed.Scheme == "http" || parsed.Scheme == "https")
+	return nil
 }
 
-func (r *ShortURLReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	// Initialize Redis client
-	log := log.Log.WithName("setup")
-	log.Info("Initializing Redis client")
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: constants.RedisServiceAddr,
+// publish emits a lifecycle event of eventType about shortURL, with the message of its Ready condition
+func (r *ShortURLReconciler) publish(shortURL *urlshortenerv1.ShortURL, eventType string) {
+	data := events.ShortURLEvent{
+		Namespace: shortURL.Namespace,
+		Name:      shortURL.Name,
+		ShortPath: shortURL.Status.ShortPath,
+		TargetURL: shortURL.Spec.TargetURL,
+		Phase:     string(shortURL.Status.Phase),
+	}
+	if ready := meta.FindStatusCondition(shortURL.Status.Conditions, urlshortenerv1.ConditionReady); ready != nil {
+		data.Message = ready.Message
+	}
+	r.Events.Publish(events.New(events.SourceController, eventType, shortURL.Namespace+"/"+shortURL.Name, data))
+}
+
+// setCondition records a condition observed at the current generation
+func setCondition(shortURL *urlshortenerv1.ShortURL, condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&shortURL.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: shortURL.Generation,
+		Reason:             reason,
+		Message:            message,
 	})
+}
+
+// claimShortPath stores the mapping under the custom path if one is requested, under the current path
+// if it still matches the spec or the path policy is Stable, otherwise under the shortest prefix of the
+// target URL hash that is free or already shared by ShortURLs with the same link. A current path that
+// can't be kept is released, so it doesn't keep serving a stale link, unless it is stable and shared with
+// other ShortURLs, which returns errPathShared.
+func (r *ShortURLReconciler) claimShortPath(ctx context.Context, shortURL *urlshortenerv1.ShortURL, owner string, link storage.Link) (string, urlshortenerv1.PathStrategy, error) {
+	if shortURL.Spec.CustomPath != "" {
+		err := r.Storage.ClaimURL(ctx, shortURL.Spec.CustomPath, owner, link)
+		return shortURL.Spec.CustomPath, urlshortenerv1.PathStrategyCustom, err
+	}
 
-	// Wait for Redis to be ready
-	for {
-		_, err := redisClient.Ping(context.Background()).Result()
+	log := log.FromContext(ctx)
+	// Settings like the redirect type are part of the link, so changing them conflicts with the link
+	// stored under the current path even though the path still matches the spec. Update the path in
+	// place unless it is shared.
+	if shortURL.Status.ShortPath != "" && r.shortPathMatchesSpec(shortURL) {
+		err := r.Storage.ShareURL(ctx, shortURL.Status.ShortPath, owner, link)
+		if errors.Is(err, storage.ErrPathConflict) {
+			err = r.Storage.RetargetURL(ctx, shortURL.Status.ShortPath, owner, link)
+		}
 		if err == nil {
-			break
+			return shortURL.Status.ShortPath, shortURL.Status.PathStrategy, nil
+		}
+		if !errors.Is(err, storage.ErrPathConflict) {
+			return "", "", err
+		}
+		// Updating the link would move the other owners along. A stable path has been handed out
+		// and must keep its hash, so leave it alone, otherwise give it up to them.
+		if r.keepsGeneratedPath(shortURL) {
+			return "", "", fmt.Errorf("%w: %s is used by other ShortURLs with a different link", errPathShared, shortURL.Status.ShortPath)
+		}
+		log.Info("Short path is shared, moving to a new one", "path", shortURL.Status.ShortPath, "reason", err.Error())
+		if err := r.Storage.ReleaseURL(ctx, shortURL.Status.ShortPath, owner); err != nil {
+			return "", "", err
 		}
-		log.Info("Waiting for Redis to be ready...")
-		time.Sleep(1 * time.Second)
 	}
 
-	r.RedisService, _ = redisHandler.NewRedisService(constants.RedisServiceAddr)
-	// Start HTTP server
-	redirectServer := httpserver.NewRedirectServer(r.RedisService)
-	go func() {
-		if err := redirectServer.Start(); err != nil {
-			log.Error(err, "unable to start HTTP server")
-			os.Exit(1)
+	encoded := r.hashTargetURL(shortURL.Spec.TargetURL)
+	for length := constants.ShortPathLength; length <= len(encoded); length++ {
+		shortPath := "/" + encoded[:length]
+		err := r.Storage.ShareURL(ctx, shortPath, owner, link)
+		if errors.Is(err, storage.ErrPathConflict) {
+			log.Info("Hash collision, extending short path", "path", shortPath)
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if length > constants.ShortPathLength {
+			return shortPath, urlshortenerv1.PathStrategyExtendedHash, nil
 		}
-	}()
+		return shortPath, urlshortenerv1.PathStrategyHash, nil
+	}
+	return "", "", fmt.Errorf("no free short path for %s", shortURL.Spec.TargetURL)
+}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&urlshortenerv1.ShortURL{}).
-		Complete(r)
+// expirationTime returns when the link expires, from spec.expiresAt or creation time plus spec.ttl,
+// or nil if it never does
+func (r *ShortURLReconciler) expirationTime(shortURL *urlshortenerv1.ShortURL) *metav1.Time {
+	switch {
+	case shortURL.Spec.ExpiresAt != nil:
+		expiresAt := shortURL.Spec.ExpiresAt.Rfc3339Copy()
+		return &expiresAt
+	case shortURL.Spec.TTL != nil:
+		expiresAt := metav1.NewTime(shortURL.CreationTimestamp.Add(shortURL.Spec.TTL.Duration)).Rfc3339Copy()
+		return &expiresAt
+	}
+	return nil
+}
+
+// shortPathMatchesSpec reports whether the current short path is still valid for the spec,
+// i.e. it is the requested custom path or a generated prefix of the target URL hash. Prefixes
+// shorter than SHORT_PATH_LENGTH still match, so raising it doesn't move published links.
+func (r *ShortURLReconciler) shortPathMatchesSpec(shortURL *urlshortenerv1.ShortURL) bool {
+	if shortURL.Spec.CustomPath != "" {
+		return shortURL.Status.ShortPath == shortURL.Spec.CustomPath
+	}
+	if r.keepsGeneratedPath(shortURL) {
+		return true
+	}
+	return shortURL.Status.PathStrategy != urlshortenerv1.PathStrategyCustom &&
+		len(shortURL.Status.ShortPath) > 1 && strings.HasPrefix("/"+r.hashTargetURL(shortURL.Spec.TargetURL), shortURL.Status.ShortPath)
+}
+
+// keepsGeneratedPath reports whether the current generated short path should follow target URL changes
+func (r *ShortURLReconciler) keepsGeneratedPath(shortURL *urlshortenerv1.ShortURL) bool {
+	return shortURL.Spec.PathPolicy == urlshortenerv1.PathPolicyStable && shortURL.Spec.CustomPath == "" &&
+		(shortURL.Status.PathStrategy == urlshortenerv1.PathStrategyHash ||
+			shortURL.Status.PathStrategy == urlshortenerv1.PathStrategyExtendedHash)
+}
+
+func sumClicks(buckets []storage.ClickBucket) int64 {
+	var total int64
+	for _, bucket := range buckets {
+		total += bucket.Count
+	}
+	return total
+}
+
+// pathMetrics reports whether the ShortURL opted in to redirect metrics labelled with its short path
+func pathMetrics(shortURL *urlshortenerv1.ShortURL) bool {
+	return shortURL.Annotations[urlshortenerv1.AnnotationPathMetrics] == "true"
+}
+
+func (r *ShortURLReconciler) hashTargetURL(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (r *ShortURLReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&urlshortenerv1.ShortURL{})
+	if r.ResyncEvents != nil {
+		builder = builder.WatchesRawSource(source.Channel(r.ResyncEvents, &handler.EnqueueRequestForObject{}))
+	}
+	return builder.Complete(r)
 }
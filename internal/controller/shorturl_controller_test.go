@@ -0,0 +1,485 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	urlshortenerv1 "github.com/abexamir/url-shortener-operator/api/v1"
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+const (
+	testNamespace = "default"
+	testTarget    = "https://example.com/campaign"
+)
+
+// testEnv reconciles ShortURLs held by a fake client into memory storage
+type testEnv struct {
+	t          *testing.T
+	ctx        context.Context
+	client     client.Client
+	store      *storage.MemoryStorage
+	reconciler *ShortURLReconciler
+}
+
+func newTestEnv(t *testing.T, shortURLs ...*urlshortenerv1.ShortURL) *testEnv {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := urlshortenerv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&urlshortenerv1.ShortURL{})
+	for _, shortURL := range shortURLs {
+		builder = builder.WithObjects(shortURL)
+	}
+	c := builder.Build()
+	store := storage.NewMemoryStorage()
+	return &testEnv{
+		t:          t,
+		ctx:        context.Background(),
+		client:     c,
+		store:      store,
+		reconciler: &ShortURLReconciler{Client: c, Scheme: scheme, Storage: store},
+	}
+}
+
+func newShortURL(name string, spec urlshortenerv1.ShortURLSpec) *urlshortenerv1.ShortURL {
+	return &urlshortenerv1.ShortURL{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name},
+		Spec:       spec,
+	}
+}
+
+func (e *testEnv) reconcile(name string) {
+	e.t.Helper()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: name}}
+	if _, err := e.reconciler.Reconcile(e.ctx, req); err != nil {
+		e.t.Fatalf("reconciling %s: %v", name, err)
+	}
+}
+
+func (e *testEnv) get(name string) *urlshortenerv1.ShortURL {
+	e.t.Helper()
+	shortURL := &urlshortenerv1.ShortURL{}
+	if err := e.client.Get(e.ctx, types.NamespacedName{Namespace: testNamespace, Name: name}, shortURL); err != nil {
+		e.t.Fatalf("getting %s: %v", name, err)
+	}
+	return shortURL
+}
+
+// update changes the spec or metadata of a ShortURL and reconciles it
+func (e *testEnv) update(name string, change func(*urlshortenerv1.ShortURL)) {
+	e.t.Helper()
+	shortURL := e.get(name)
+	change(shortURL)
+	if err := e.client.Update(e.ctx, shortURL); err != nil {
+		e.t.Fatalf("updating %s: %v", name, err)
+	}
+	e.reconcile(name)
+}
+
+// delete deletes a ShortURL and reconciles it, which removes its finalizer
+func (e *testEnv) delete(name string) {
+	e.t.Helper()
+	if err := e.client.Delete(e.ctx, e.get(name)); err != nil {
+		e.t.Fatalf("deleting %s: %v", name, err)
+	}
+	e.reconcile(name)
+}
+
+func (e *testEnv) link(shortPath string) storage.Link {
+	e.t.Helper()
+	link, err := e.store.GetLink(e.ctx, shortPath)
+	if err != nil {
+		e.t.Fatalf("getting link of %s: %v", shortPath, err)
+	}
+	return link
+}
+
+func (e *testEnv) expectGone(shortPath string) {
+	e.t.Helper()
+	if _, err := e.store.GetLink(e.ctx, shortPath); !errors.Is(err, storage.ErrNotFound) {
+		e.t.Errorf("expected %s to be gone, got %v", shortPath, err)
+	}
+}
+
+// reconcileTest reconciles ShortURLs once each, then hands them to run to change them and check
+// the outcome
+type reconcileTest struct {
+	name      string
+	shortURLs []*urlshortenerv1.ShortURL
+	run       func(t *testing.T, e *testEnv)
+}
+
+func runReconcileTests(t *testing.T, tests []reconcileTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t, tt.shortURLs...)
+			for _, shortURL := range tt.shortURLs {
+				e.reconcile(shortURL.Name)
+			}
+			tt.run(t, e)
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	runReconcileTests(t, []reconcileTest{
+		{
+			name:      "create",
+			shortURLs: []*urlshortenerv1.ShortURL{newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget})},
+			run: func(t *testing.T, e *testEnv) {
+				a := e.get("a")
+				if a.Status.Phase != urlshortenerv1.PhaseActive || a.Status.PathStrategy != urlshortenerv1.PathStrategyHash {
+					t.Fatalf("unexpected status %+v", a.Status)
+				}
+				if len(a.Status.ShortPath) != 4 {
+					t.Errorf("expected a 3 character short path, got %q", a.Status.ShortPath)
+				}
+				if !slices.Contains(a.Finalizers, shortURLFinalizer) {
+					t.Errorf("expected finalizer, got %v", a.Finalizers)
+				}
+				link := e.link(a.Status.ShortPath)
+				if link.TargetURL != testTarget || !slices.Equal(link.Owners, []string{"default/a"}) {
+					t.Errorf("unexpected link %+v", link)
+				}
+			},
+		},
+	})
+}
+
+func TestReconcilePathMetrics(t *testing.T) {
+	runReconcileTests(t, []reconcileTest{
+		{
+			name: "path metrics toggle",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				e.update("a", func(a *urlshortenerv1.ShortURL) {
+					a.Annotations = map[string]string{urlshortenerv1.AnnotationPathMetrics: "true"}
+				})
+				if a := e.get("a"); a.Status.ShortPath != shortPath {
+					t.Errorf("expected the path to stay %q, got %q", shortPath, a.Status.ShortPath)
+				}
+				if link := e.link(shortPath); !link.PathMetrics {
+					t.Errorf("expected path metrics to be enabled, got %+v", link)
+				}
+			},
+		},
+		{
+			name: "path metrics toggle on a shared path",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget, PathPolicy: urlshortenerv1.PathPolicyStable}),
+				newShortURL("b", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				if b := e.get("b"); b.Status.ShortPath != shortPath {
+					t.Fatalf("expected b to share %q, got %q", shortPath, b.Status.ShortPath)
+				}
+
+				for _, enabled := range []string{"true", "false"} {
+					e.update("a", func(a *urlshortenerv1.ShortURL) {
+						a.Annotations = map[string]string{urlshortenerv1.AnnotationPathMetrics: enabled}
+					})
+					e.reconcile("b")
+					for _, name := range []string{"a", "b"} {
+						shortURL := e.get(name)
+						if shortURL.Status.ShortPath != shortPath || shortURL.Status.Phase != urlshortenerv1.PhaseActive {
+							t.Errorf("expected %s to stay active on %q, got %q in phase %s",
+								name, shortPath, shortURL.Status.ShortPath, shortURL.Status.Phase)
+						}
+					}
+					link := e.link(shortPath)
+					if !slices.Equal(link.Owners, []string{"default/a", "default/b"}) {
+						t.Errorf("expected both owners to share the path, got %v", link.Owners)
+					}
+					if link.PathMetrics != (enabled == "true") {
+						t.Errorf("expected path metrics %s, got %+v", enabled, link)
+					}
+				}
+			},
+		},
+	})
+}
+
+func TestReconcileExpiry(t *testing.T) {
+	past := metav1.NewTime(time.Now().Add(-time.Hour))
+	future := metav1.NewTime(time.Now().Add(time.Hour))
+
+	runReconcileTests(t, []reconcileTest{
+		{
+			name: "expiry",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget, ExpiresAt: &past}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				a := e.get("a")
+				if a.Status.Phase != urlshortenerv1.PhaseExpired || a.Status.ExpiresAt == nil {
+					t.Errorf("expected the link to be expired, got %+v", a.Status)
+				}
+			},
+		},
+		{
+			name: "expiry of a shared path",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget, ExpiresAt: &future}),
+				newShortURL("b", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("b").Status.ShortPath
+				// b never expires, so neither does the path
+				if expired, err := e.store.IsExpired(e.ctx, shortPath); err != nil || expired {
+					t.Errorf("expected the path not to be marked as expiring, got %v, %v", expired, err)
+				}
+
+				e.update("a", func(a *urlshortenerv1.ShortURL) { a.Spec.ExpiresAt = &past })
+				if a := e.get("a"); a.Status.Phase != urlshortenerv1.PhaseExpired || len(a.Status.SharedWith) != 0 {
+					t.Errorf("expected a to be expired and no longer shared, got %+v", a.Status)
+				}
+				if link := e.link(shortPath); !slices.Equal(link.Owners, []string{"default/b"}) {
+					t.Errorf("expected a to release the path, got owners %v", link.Owners)
+				}
+				e.reconcile("b")
+				if b := e.get("b"); len(b.Status.SharedWith) != 0 {
+					t.Errorf("expected b to no longer share the path, got %v", b.Status.SharedWith)
+				}
+				e.update("b", func(b *urlshortenerv1.ShortURL) {
+					b.Spec.PathPolicy = urlshortenerv1.PathPolicyStable
+				})
+				e.update("b", func(b *urlshortenerv1.ShortURL) { b.Spec.TargetURL = "https://example.com/other" })
+				if link := e.link(shortPath); link.TargetURL != "https://example.com/other" {
+					t.Errorf("expected b to retarget the path, got %+v", link)
+				}
+			},
+		},
+	})
+}
+
+func TestReconcileDelete(t *testing.T) {
+	runReconcileTests(t, []reconcileTest{
+		{
+			name:      "delete",
+			shortURLs: []*urlshortenerv1.ShortURL{newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget})},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				if err := e.store.IncrementClickCount(e.ctx, shortPath); err != nil {
+					t.Fatal(err)
+				}
+				e.delete("a")
+				e.expectGone(shortPath)
+				if count, err := e.store.GetClickCount(e.ctx, shortPath); err != nil || count != 0 {
+					t.Errorf("expected the click count to be removed, got %d, %v", count, err)
+				}
+				err := e.client.Get(e.ctx, types.NamespacedName{Namespace: testNamespace, Name: "a"}, &urlshortenerv1.ShortURL{})
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("expected the ShortURL to be gone once its finalizer is removed, got %v", err)
+				}
+			},
+		},
+	})
+}
+
+func TestReconcileSharedPath(t *testing.T) {
+	runReconcileTests(t, []reconcileTest{
+		{
+			name: "shared target",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+				newShortURL("b", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				e.reconcile("a")
+				a, b := e.get("a"), e.get("b")
+				if a.Status.ShortPath != b.Status.ShortPath {
+					t.Fatalf("expected a shared path, got %q and %q", a.Status.ShortPath, b.Status.ShortPath)
+				}
+				if !slices.Equal(a.Status.SharedWith, []string{"default/b"}) ||
+					!slices.Equal(b.Status.SharedWith, []string{"default/a"}) {
+					t.Errorf("unexpected sharedWith %v and %v", a.Status.SharedWith, b.Status.SharedWith)
+				}
+			},
+		},
+		{
+			name: "delete one sharer",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+				newShortURL("b", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				e.delete("a")
+				if link := e.link(shortPath); !slices.Equal(link.Owners, []string{"default/b"}) {
+					t.Errorf("expected b to keep the path, got owners %v", link.Owners)
+				}
+				e.reconcile("b")
+				if b := e.get("b"); b.Status.ShortPath != shortPath || len(b.Status.SharedWith) != 0 {
+					t.Errorf("unexpected status %+v", b.Status)
+				}
+				e.delete("b")
+				e.expectGone(shortPath)
+			},
+		},
+	})
+}
+
+func TestReconcileRedirectType(t *testing.T) {
+	runReconcileTests(t, []reconcileTest{
+		{
+			name: "redirectType change",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				e.update("a", func(a *urlshortenerv1.ShortURL) { a.Spec.RedirectType = 301 })
+				if a := e.get("a"); a.Status.ShortPath != shortPath {
+					t.Errorf("expected the path to stay %q, got %q", shortPath, a.Status.ShortPath)
+				}
+				if link := e.link(shortPath); link.RedirectType != 301 {
+					t.Errorf("expected the redirect type to be updated, got %+v", link)
+				}
+			},
+		},
+		{
+			name: "redirectType change of a shared path",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+				newShortURL("b", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				e.update("a", func(a *urlshortenerv1.ShortURL) { a.Spec.RedirectType = 301 })
+				a := e.get("a")
+				if a.Status.ShortPath == shortPath || a.Status.PathStrategy != urlshortenerv1.PathStrategyExtendedHash {
+					t.Fatalf("expected a to move to a longer path, got %+v", a.Status)
+				}
+				if link := e.link(a.Status.ShortPath); link.RedirectType != 301 {
+					t.Errorf("unexpected link %+v", link)
+				}
+				link := e.link(shortPath)
+				if link.RedirectType != 0 || !slices.Equal(link.Owners, []string{"default/b"}) {
+					t.Errorf("expected b to keep the old link, got %+v", link)
+				}
+			},
+		},
+	})
+}
+
+func TestReconcilePathPolicy(t *testing.T) {
+	runReconcileTests(t, []reconcileTest{
+		{
+			name: "stable retarget",
+			shortURLs: []*urlshortenerv1.ShortURL{newShortURL("a", urlshortenerv1.ShortURLSpec{
+				TargetURL:  testTarget,
+				PathPolicy: urlshortenerv1.PathPolicyStable,
+			})},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				e.update("a", func(a *urlshortenerv1.ShortURL) { a.Spec.TargetURL = "https://example.com/other" })
+				if a := e.get("a"); a.Status.ShortPath != shortPath {
+					t.Errorf("expected the path to stay %q, got %q", shortPath, a.Status.ShortPath)
+				}
+				if link := e.link(shortPath); link.TargetURL != "https://example.com/other" {
+					t.Errorf("expected the path to be retargeted, got %+v", link)
+				}
+			},
+		},
+		{
+			name: "stable retarget of a shared path",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget, PathPolicy: urlshortenerv1.PathPolicyStable}),
+				newShortURL("b", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				e.update("a", func(a *urlshortenerv1.ShortURL) { a.Spec.TargetURL = "https://example.com/other" })
+				a := e.get("a")
+				if a.Status.ShortPath != shortPath {
+					t.Errorf("expected the path to stay %q, got %q", shortPath, a.Status.ShortPath)
+				}
+				if a.Status.Phase != urlshortenerv1.PhaseConflict {
+					t.Errorf("expected phase %s, got %s", urlshortenerv1.PhaseConflict, a.Status.Phase)
+				}
+				if condition := meta.FindStatusCondition(a.Status.Conditions, urlshortenerv1.ConditionPathConflict); condition == nil ||
+					condition.Status != metav1.ConditionTrue || condition.Reason != urlshortenerv1.ReasonPathShared {
+					t.Errorf("expected a PathShared conflict, got %+v", condition)
+				}
+				if link := e.link(shortPath); link.TargetURL != testTarget {
+					t.Errorf("expected the shared link to be kept, got %+v", link)
+				}
+				if owners, _ := e.store.GetOwners(e.ctx, shortPath); len(owners) != 2 {
+					t.Errorf("expected both owners to keep the path, got %v", owners)
+				}
+
+				// Once the other owner moves along, the path follows the new target
+				e.update("b", func(b *urlshortenerv1.ShortURL) { b.Spec.TargetURL = "https://example.com/other" })
+				e.reconcile("a")
+				if a := e.get("a"); a.Status.ShortPath != shortPath || a.Status.Phase != urlshortenerv1.PhaseActive {
+					t.Errorf("expected %q to be active, got %q in phase %s", shortPath, a.Status.ShortPath, a.Status.Phase)
+				}
+				if link := e.link(shortPath); link.TargetURL != "https://example.com/other" {
+					t.Errorf("expected the path to be retargeted, got %+v", link)
+				}
+			},
+		},
+		{
+			name: "rehash on target change",
+			shortURLs: []*urlshortenerv1.ShortURL{
+				newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}),
+			},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				e.update("a", func(a *urlshortenerv1.ShortURL) { a.Spec.TargetURL = "https://example.com/other" })
+				if a := e.get("a"); a.Status.ShortPath == shortPath {
+					t.Errorf("expected a new path, got %q", a.Status.ShortPath)
+				}
+				e.expectGone(shortPath)
+			},
+		},
+	})
+}
+
+func TestReconcileShortPathLength(t *testing.T) {
+	runReconcileTests(t, []reconcileTest{
+		{
+			name:      "raised length keeps existing paths",
+			shortURLs: []*urlshortenerv1.ShortURL{newShortURL("a", urlshortenerv1.ShortURLSpec{TargetURL: testTarget})},
+			run: func(t *testing.T, e *testEnv) {
+				shortPath := e.get("a").Status.ShortPath
+				defer func(length int) { constants.ShortPathLength = length }(constants.ShortPathLength)
+				constants.ShortPathLength = 6
+
+				e.reconcile("a")
+				if a := e.get("a"); a.Status.ShortPath != shortPath {
+					t.Errorf("expected the path to stay %q, got %q", shortPath, a.Status.ShortPath)
+				}
+				e.link(shortPath)
+
+				other := newShortURL("b", urlshortenerv1.ShortURLSpec{TargetURL: "https://example.com/other"})
+				if err := e.client.Create(e.ctx, other); err != nil {
+					t.Fatal(err)
+				}
+				e.reconcile("b")
+				if b := e.get("b"); len(b.Status.ShortPath) != 7 {
+					t.Errorf("expected new paths to use the new length, got %q", b.Status.ShortPath)
+				}
+			},
+		},
+	})
+}
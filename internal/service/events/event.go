@@ -0,0 +1,73 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+// Event types, following the CloudEvents reverse-DNS convention
+const (
+	TypeClick              = "ir.tapsi.urlshortener.click.v1"
+	TypeShortURLActivated  = "ir.tapsi.urlshortener.shorturl.activated.v1"
+	TypeShortURLConflicted = "ir.tapsi.urlshortener.shorturl.conflicted.v1"
+	TypeShortURLExpired    = "ir.tapsi.urlshortener.shorturl.expired.v1"
+	TypeShortURLDeleted    = "ir.tapsi.urlshortener.shorturl.deleted.v1"
+)
+
+// Sources of the events
+const (
+	SourceRedirectServer = "/url-shortener/redirect-server"
+	SourceController     = "/url-shortener/controller"
+)
+
+// Event is a CloudEvents 1.0 event in its structured JSON form
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            time.Time   `json:"time"`
+	Subject         string      `json:"subject,omitempty"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// ShortURLEvent is the data of the ShortURL lifecycle events
+type ShortURLEvent struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	ShortPath string `json:"shortPath,omitempty"`
+	TargetURL string `json:"targetURL"`
+	Phase     string `json:"phase,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// New returns an event of eventType about subject
+func New(source, eventType, subject string, data interface{}) Event {
+	return Event{
+		SpecVersion:     "1.0",
+		ID:              newID(),
+		Source:          source,
+		Type:            eventType,
+		Time:            time.Now().UTC(),
+		Subject:         subject,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// NewClick returns the event of a click on a short path
+func NewClick(click storage.ClickEvent) Event {
+	event := New(SourceRedirectServer, TypeClick, click.ShortPath, click)
+	event.Time = click.Time.UTC()
+	return event
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
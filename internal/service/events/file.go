@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// FileSink writes events as JSON lines to a local file. Once the file would grow past MaxSize it is
+// rotated to path.1, path.1 to path.2 and so on, keeping up to MaxBackups old files.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+var _ Sink = &FileSink{}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Send(_ context.Context, events []Event) error {
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return Permanent(err)
+		}
+		line = append(line, '\n')
+		if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts the backups by one, dropping the oldest, and starts a new file
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i > 0; i-- {
+			err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
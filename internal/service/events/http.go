@@ -0,0 +1,75 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// batchContentType is the CloudEvents batched content mode of the HTTP binding
+const batchContentType = "application/cloudevents-batch+json"
+
+// HTTPSink POSTs batches of events to a URL in the CloudEvents batched content mode. Responses
+// other than 2xx fail the batch; 408, 429 and 5xx are retried, other client errors aren't.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+	// Header is added to every request, e.g. for authorization
+	Header http.Header
+}
+
+var _ Sink = &HTTPSink{}
+
+// NewHTTPSink returns a sink posting to url with client, or with a client timing out after
+// 10 seconds if client is nil. Tests can pass the URL and client of an httptest.Server.
+func NewHTTPSink(url string, client *http.Client) *HTTPSink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &HTTPSink{url: url, client: client, Header: http.Header{}}
+}
+
+func (s *HTTPSink) Name() string {
+	return "http"
+}
+
+func (s *HTTPSink) Send(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", batchContentType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return fmt.Errorf("event receiver answered %s", resp.Status)
+	default:
+		return Permanent(fmt.Errorf("event receiver answered %s", resp.Status))
+	}
+}
+
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+// receiver is an httptest event receiver answering with the given status codes in turn, and
+// with 202 Accepted once they run out
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	// requests holds the content type and decoded body of every request
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	contentType   string
+	authorization string
+	events        []map[string]interface{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var events []map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&events); err != nil {
+			t.Errorf("decoding batch: %v", err)
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedRequest{
+			contentType:   req.Header.Get("Content-Type"),
+			authorization: req.Header.Get("Authorization"),
+			events:        events,
+		})
+		status := http.StatusAccepted
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func testClick(shortPath string) Event {
+	return NewClick(storage.ClickEvent{Time: time.Now(), ShortPath: shortPath, Namespace: "default", Name: "a"})
+}
+
+func TestHTTPSinkSend(t *testing.T) {
+	r := newReceiver(t)
+	sink := NewHTTPSink(r.URL, r.Client())
+	sink.Header.Set("Authorization", "Bearer secret")
+
+	if err := sink.Send(context.Background(), []Event{testClick("/abc"), testClick("/def")}); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	request := requests[0]
+	if request.contentType != "application/cloudevents-batch+json" {
+		t.Errorf("unexpected content type %q", request.contentType)
+	}
+	if request.authorization != "Bearer secret" {
+		t.Errorf("unexpected authorization %q", request.authorization)
+	}
+	if len(request.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(request.events))
+	}
+	event := request.events[0]
+	for attribute, expected := range map[string]string{
+		"specversion":     "1.0",
+		"type":            TypeClick,
+		"source":          SourceRedirectServer,
+		"subject":         "/abc",
+		"datacontenttype": "application/json",
+	} {
+		if event[attribute] != expected {
+			t.Errorf("expected %s %q, got %v", attribute, expected, event[attribute])
+		}
+	}
+	if event["id"] == "" || event["id"] == request.events[1]["id"] {
+		t.Errorf("expected unique ids, got %v and %v", event["id"], request.events[1]["id"])
+	}
+	if data, ok := event["data"].(map[string]interface{}); !ok || data["shortPath"] != "/abc" {
+		t.Errorf("unexpected data %v", event["data"])
+	}
+}
+
+func TestHTTPSinkErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{status: http.StatusInternalServerError},
+		{status: http.StatusServiceUnavailable},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusRequestTimeout},
+		{status: http.StatusBadRequest, permanent: true},
+		{status: http.StatusUnauthorized, permanent: true},
+		{status: http.StatusNotFound, permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			r := newReceiver(t, tt.status)
+			err := NewHTTPSink(r.URL, r.Client()).Send(context.Background(), []Event{testClick("/abc")})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Errorf("expected permanent %v, got %v", tt.permanent, IsPermanent(err))
+			}
+		})
+	}
+}
+
+func TestPublisherHTTPRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		// attempts is the number of requests expected for the batch
+		attempts int
+	}{
+		{name: "delivered", attempts: 1},
+		{name: "retried on 5xx and 429", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, attempts: 3},
+		{name: "not retried on 400", statuses: []int{http.StatusBadRequest}, attempts: 1},
+		{name: "dropped after the last attempt", statuses: []int{500, 500, 500, 500, 500}, attempts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, tt.statuses...)
+			publisher := NewPublisher(PublisherOptions{
+				BatchSize: 10,
+				// Batches are only sent when the publisher stops
+				FlushInterval: time.Hour,
+				Retry:         wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3},
+			}, NewHTTPSink(r.URL, r.Client()))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- publisher.Start(ctx) }()
+			for _, shortPath := range []string{"/abc", "/def", "/ghi"} {
+				publisher.Publish(testClick(shortPath))
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			requests := r.received()
+			if len(requests) != tt.attempts {
+				t.Fatalf("expected %d requests, got %d", tt.attempts, len(requests))
+			}
+			for _, request := range requests {
+				if len(request.events) != 3 {
+					t.Errorf("expected the 3 events in one batch, got %d", len(request.events))
+				}
+			}
+		})
+	}
+}
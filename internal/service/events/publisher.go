@@ -0,0 +1,174 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/metrics"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// finalFlushTimeout bounds the delivery of the remaining events on shutdown
+const finalFlushTimeout = 5 * time.Second
+
+// PublisherOptions configures batching and retries of a Publisher
+type PublisherOptions struct {
+	// QueueSize is the number of events buffered per sink before new ones are dropped
+	QueueSize int
+	// BatchSize is the number of events sent at once
+	BatchSize int
+	// FlushInterval is the longest an event waits for its batch to fill up
+	FlushInterval time.Duration
+	// Retry is the backoff between attempts to send a batch, which is dropped once its steps run out
+	Retry wait.Backoff
+}
+
+// DefaultPublisherOptions returns the options used for unset fields
+func DefaultPublisherOptions() PublisherOptions {
+	return PublisherOptions{
+		QueueSize:     10000,
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		Retry: wait.Backoff{
+			Duration: 500 * time.Millisecond,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    5,
+			Cap:      30 * time.Second,
+		},
+	}
+}
+
+// Publisher fans events out to sinks off the caller's path. Each sink has its own queue, batches
+// and retries, so a slow sink doesn't hold the others back. Publishing never blocks; events are
+// dropped when the queue of a sink is full. A nil Publisher drops everything.
+type Publisher struct {
+	options PublisherOptions
+	workers []*sinkWorker
+}
+
+type sinkWorker struct {
+	sink  Sink
+	queue chan Event
+}
+
+// NewPublisher returns a publisher delivering to sinks
+func NewPublisher(options PublisherOptions, sinks ...Sink) *Publisher {
+	defaults := DefaultPublisherOptions()
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaults.QueueSize
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaults.BatchSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaults.FlushInterval
+	}
+	if options.Retry.Steps <= 0 {
+		options.Retry = defaults.Retry
+	}
+
+	p := &Publisher{options: options}
+	for _, sink := range sinks {
+		p.workers = append(p.workers, &sinkWorker{sink: sink, queue: make(chan Event, options.QueueSize)})
+	}
+	return p
+}
+
+// Publish queues event for every sink without blocking
+func (p *Publisher) Publish(event Event) {
+	if p == nil {
+		return
+	}
+	for _, worker := range p.workers {
+		select {
+		case worker.queue <- event:
+		default:
+			metrics.EventsDropped.WithLabelValues(worker.sink.Name()).Inc()
+		}
+	}
+}
+
+// Start delivers events until ctx is cancelled, then delivers what is queued and closes the sinks.
+// Cancel ctx only once everything that publishes has stopped, events published later are dropped.
+func (p *Publisher) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, worker := range p.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.run(ctx, worker)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (p *Publisher) run(ctx context.Context, worker *sinkWorker) {
+	log := ctrllog.FromContext(ctx).WithValues("sink", worker.sink.Name())
+	ctx = ctrllog.IntoContext(ctx, log)
+	defer func() {
+		if err := worker.sink.Close(); err != nil {
+			log.Error(err, "Failed to close event sink")
+		}
+	}()
+
+	ticker := time.NewTicker(p.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, p.options.BatchSize)
+	for {
+		select {
+		case event := <-worker.queue:
+			if batch = append(batch, event); len(batch) >= p.options.BatchSize {
+				p.send(ctx, worker.sink, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.send(ctx, worker.sink, batch)
+			batch = batch[:0]
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(ctrllog.IntoContext(context.Background(), log), finalFlushTimeout)
+			defer cancel()
+			for {
+				select {
+				case event := <-worker.queue:
+					if batch = append(batch, event); len(batch) >= p.options.BatchSize {
+						p.send(flushCtx, worker.sink, batch)
+						batch = batch[:0]
+					}
+				default:
+					p.send(flushCtx, worker.sink, batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send delivers batch, retrying with backoff until it succeeds, fails permanently, the attempts
+// run out or ctx is done. Batches that can't be delivered are dropped.
+func (p *Publisher) send(ctx context.Context, sink Sink, batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, p.options.Retry, func(ctx context.Context) (bool, error) {
+		lastErr = sink.Send(ctx, batch)
+		if lastErr != nil && IsPermanent(lastErr) {
+			return false, lastErr
+		}
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		if lastErr == nil {
+			lastErr = err
+		}
+		ctrllog.FromContext(ctx).Error(lastErr, "Failed to send events, dropping them", "events", len(batch))
+		metrics.EventsDropped.WithLabelValues(sink.Name()).Add(float64(len(batch)))
+		return
+	}
+	metrics.EventsPublished.WithLabelValues(sink.Name()).Add(float64(len(batch)))
+}
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"context"
+	"errors"
+)
+
+// Sink delivers events somewhere. The Publisher calls Send from a single goroutine per sink.
+type Sink interface {
+	// Name identifies the sink in logs and metrics
+	Name() string
+	// Send delivers a batch of events. Errors are retried unless they are permanent.
+	Send(ctx context.Context, events []Event) error
+	// Close releases the resources of the sink once no more events are sent
+	Close() error
+}
+
+// permanentError marks errors that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
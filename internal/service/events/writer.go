@@ -0,0 +1,43 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// WriterSink writes events as JSON lines, e.g. to stdout for a log collector to pick up
+type WriterSink struct {
+	name    string
+	encoder *json.Encoder
+}
+
+var _ Sink = &WriterSink{}
+
+// NewWriterSink returns a sink writing to w
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, encoder: json.NewEncoder(w)}
+}
+
+// NewStdoutSink returns a sink writing to stdout
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink("stdout", os.Stdout)
+}
+
+func (s *WriterSink) Name() string {
+	return s.name
+}
+
+func (s *WriterSink) Send(_ context.Context, events []Event) error {
+	for _, event := range events {
+		if err := s.encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package httpserver
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+// lookup is the outcome of resolving a short path
+type lookup struct {
+	link  storage.Link
+	found bool
+	// expired is set for paths that aren't found because they have expired
+	expired bool
+}
+
+type cacheEntry struct {
+	shortPath string
+	lookup    lookup
+	expiresAt time.Time
+}
+
+// linkCache is a bounded LRU cache of short path lookups, including paths that weren't found.
+// Entries are dropped when storage announces a change of their path, and in any case after a TTL
+// in case an announcement is missed.
+type linkCache struct {
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]*list.Element
+	order       *list.List // most recently used first
+	// generation is bumped on every invalidation, so lookups that raced with one aren't cached
+	generation uint64
+	now        func() time.Time
+}
+
+func newLinkCache(size int, ttl, negativeTTL time.Duration) *linkCache {
+	return &linkCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]*list.Element, size),
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+// get returns the cached lookup of shortPath, if there is a fresh one
+func (c *linkCache) get(shortPath string) (lookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[shortPath]
+	if !ok {
+		return lookup{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return lookup{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.lookup, true
+}
+
+// currentGeneration is to be read before looking up a path in storage and passed to add
+func (c *linkCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// add caches the lookup of shortPath unless the cache was invalidated since generation.
+// Links are never cached past their own expiry.
+func (c *linkCache) add(shortPath string, result lookup, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	ttl := c.ttl
+	if !result.found {
+		ttl = c.negativeTTL
+	} else if result.link.TTL > 0 && result.link.TTL < ttl {
+		ttl = result.link.TTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{shortPath: shortPath, lookup: result, expiresAt: c.now().Add(ttl)}
+	if element, ok := c.entries[shortPath]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[shortPath] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate drops shortPath from the cache, or everything if shortPath is empty
+func (c *linkCache) invalidate(shortPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if shortPath == "" {
+		c.entries = make(map[string]*list.Element, c.size)
+		c.order.Init()
+		return
+	}
+	if element, ok := c.entries[shortPath]; ok {
+		c.remove(element)
+	}
+}
+
+// remove drops element from the cache. Callers must hold c.mu.
+func (c *linkCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).shortPath)
+}
@@ -0,0 +1,213 @@
+package httpserver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/events"
+	"github.com/abexamir/url-shortener-operator/internal/service/metrics"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// finalFlushTimeout bounds the flush of the remaining clicks on shutdown
+const finalFlushTimeout = 5 * time.Second
+
+// click is a redirect to be counted. The request details are only parsed when it is aggregated,
+// off the request path.
+type click struct {
+	time      time.Time
+	shortPath string
+	// owners of the short path, in namespace/name order
+	owners    []string
+	clientIP  string
+	userAgent string
+	referrer  string
+}
+
+// clickCounter takes clicks off the request path. Clicks are queued, aggregated per short path and
+// written to storage in one batch per interval. When the queue is full, or storage stays unavailable
+// for long enough that the pending batches outgrow the queue, clicks are dropped rather than slowing
+// redirects down.
+type clickCounter struct {
+	storage  storage.Storage
+	queue    chan click
+	interval time.Duration
+	// pending holds the clicks aggregated since the last successful flush
+	pending map[string]*storage.ClickBatch
+	// pendingSize counts the short paths, distinct visitors and breakdown values in pending
+	pendingSize int
+	// geoIP resolves the country of clients, nil to leave countries out
+	geoIP *GeoIP
+	// stream receives an event per click, nil to not record events
+	stream storage.ClickEventStream
+	// events holds the click events since the last successful flush
+	events []storage.ClickEvent
+	// publisher receives an event per click, nil to not publish events
+	publisher *events.Publisher
+}
+
+func newClickCounter(store storage.Storage, queueSize int, interval time.Duration) *clickCounter {
+	return &clickCounter{
+		storage:  store,
+		queue:    make(chan click, queueSize),
+		interval: interval,
+		pending:  map[string]*storage.ClickBatch{},
+	}
+}
+
+// record counts a click without blocking
+func (c *clickCounter) record(click click) {
+	select {
+	case c.queue <- click:
+	default:
+		metrics.ClicksDropped.Inc()
+	}
+}
+
+// run aggregates and flushes clicks until ctx is cancelled, then flushes what is left.
+// Callers should stop calling record before cancelling ctx.
+func (c *clickCounter) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case click := <-c.queue:
+			c.add(click)
+			if c.pendingSize >= cap(c.queue) || len(c.events) >= cap(c.queue) {
+				c.flush(ctx)
+			}
+		case <-ticker.C:
+			c.flush(ctx)
+		case <-ctx.Done():
+			c.drain()
+			flushCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+			defer cancel()
+			c.flush(flushCtx)
+			return
+		}
+	}
+}
+
+// add aggregates click into the pending batch of its short path
+func (c *clickCounter) add(click click) {
+	batch, ok := c.pending[click.shortPath]
+	if !ok {
+		batch = &storage.ClickBatch{
+			Visitors:   map[string]struct{}{},
+			Breakdowns: map[storage.Dimension]map[string]int64{},
+		}
+		c.pending[click.shortPath] = batch
+		c.pendingSize++
+	}
+	batch.Count++
+	if c.stream != nil || c.publisher != nil {
+		event := clickEvent(click)
+		if c.stream != nil {
+			c.events = append(c.events, event)
+		}
+		c.publisher.Publish(events.NewClick(event))
+	}
+
+	visitor := visitorFingerprint(click.clientIP, click.userAgent)
+	if _, ok := batch.Visitors[visitor]; !ok {
+		batch.Visitors[visitor] = struct{}{}
+		c.pendingSize++
+	}
+
+	values := map[storage.Dimension]string{
+		storage.DimensionReferrer: referrerHost(click.referrer),
+		storage.DimensionBrowser:  browserFamily(click.userAgent),
+		storage.DimensionOS:       osFamily(click.userAgent),
+	}
+	if c.geoIP != nil {
+		values[storage.DimensionCountry] = c.geoIP.country(click.clientIP)
+	}
+	for dimension, value := range values {
+		counts := batch.Breakdowns[dimension]
+		if counts == nil {
+			counts = map[string]int64{}
+			batch.Breakdowns[dimension] = counts
+		}
+		if _, ok := counts[value]; !ok {
+			c.pendingSize++
+		}
+		counts[value]++
+	}
+}
+
+func clickEvent(click click) storage.ClickEvent {
+	event := storage.ClickEvent{
+		Time:      click.time,
+		ShortPath: click.shortPath,
+		Referrer:  click.referrer,
+		UserAgent: click.userAgent,
+		ClientIP:  anonymizeIP(click.clientIP),
+	}
+	if len(click.owners) > 0 {
+		event.Namespace, event.Name, _ = strings.Cut(click.owners[0], "/")
+	}
+	return event
+}
+
+// drain moves the queued clicks to the pending batches
+func (c *clickCounter) drain() {
+	for {
+		select {
+		case click := <-c.queue:
+			c.add(click)
+		default:
+			return
+		}
+	}
+}
+
+// flush writes the pending batches to storage. They are kept for the next attempt if that fails,
+// unless there are too many of them.
+func (c *clickCounter) flush(ctx context.Context) {
+	c.flushEvents(ctx)
+	if len(c.pending) == 0 {
+		return
+	}
+	var total int64
+	for _, batch := range c.pending {
+		total += batch.Count
+	}
+
+	if err := c.storage.AddClicks(ctx, c.pending); err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Failed to flush click counts", "paths", len(c.pending), "clicks", total)
+		metrics.StorageErrors.WithLabelValues("add_clicks").Inc()
+		if c.pendingSize >= cap(c.queue) {
+			metrics.ClicksDropped.Add(float64(total))
+			c.reset()
+		}
+		return
+	}
+	metrics.ClicksFlushed.Add(float64(total))
+	c.reset()
+}
+
+// flushEvents appends the pending click events to the stream. Like counts, they are kept for the
+// next attempt if that fails, unless there are too many of them.
+func (c *clickCounter) flushEvents(ctx context.Context) {
+	if len(c.events) == 0 {
+		return
+	}
+	if err := c.stream.AppendClickEvents(ctx, c.events); err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Failed to append click events", "events", len(c.events))
+		metrics.StorageErrors.WithLabelValues("append_click_events").Inc()
+		if len(c.events) >= cap(c.queue) {
+			metrics.ClickEventsDropped.Add(float64(len(c.events)))
+			c.events = nil
+		}
+		return
+	}
+	c.events = nil
+}
+
+func (c *clickCounter) reset() {
+	c.pending = map[string]*storage.ClickBatch{}
+	c.pendingSize = 0
+}
@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	urlshortenerv1 "github.com/abexamir/url-shortener-operator/api/v1"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+// shortPathIndex indexes ShortURLs by status.shortPath
+const shortPathIndex = "status.shortPath"
+
+// ShortURLIndex resolves short paths from the ShortURLs in the informer cache. It only knows
+// what the controller last reported in the status, which is good enough to keep redirecting
+// while storage is unavailable.
+type ShortURLIndex struct {
+	reader client.Reader
+}
+
+// NewShortURLIndex registers the short path index with the manager's cache. The cache then
+// watches ShortURLs, which needs get, list and watch permissions on them.
+func NewShortURLIndex(ctx context.Context, mgr manager.Manager) (*ShortURLIndex, error) {
+	err := mgr.GetFieldIndexer().IndexField(ctx, &urlshortenerv1.ShortURL{}, shortPathIndex, func(obj client.Object) []string {
+		shortURL := obj.(*urlshortenerv1.ShortURL)
+		if shortURL.Status.ShortPath == "" {
+			return nil
+		}
+		return []string{shortURL.Status.ShortPath}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ShortURLIndex{reader: mgr.GetCache()}, nil
+}
+
+// resolve looks shortPath up among the ShortURLs whose status is up to date with their spec
+func (i *ShortURLIndex) resolve(ctx context.Context, shortPath string) (lookup, error) {
+	var shortURLs urlshortenerv1.ShortURLList
+	if err := i.reader.List(ctx, &shortURLs, client.MatchingFields{shortPathIndex: shortPath}); err != nil {
+		return lookup{}, err
+	}
+
+	var result lookup
+	var owners []string
+	// Path metrics are kept per owner, the path has them if any owner opted in
+	pathMetrics := false
+	for _, shortURL := range shortURLs.Items {
+		owners = append(owners, client.ObjectKeyFromObject(&shortURL).String())
+		pathMetrics = pathMetrics || shortURL.Annotations[urlshortenerv1.AnnotationPathMetrics] == "true"
+	}
+	slices.Sort(owners)
+	for _, shortURL := range shortURLs.Items {
+		// The status of a changed spec may describe a link that no longer exists
+		if shortURL.Status.ObservedGeneration != shortURL.Generation {
+			continue
+		}
+		switch {
+		case shortURL.Status.Phase == urlshortenerv1.PhaseExpired,
+			shortURL.Status.ExpiresAt != nil && !shortURL.Status.ExpiresAt.After(time.Now()):
+			result.expired = true
+		case shortURL.Status.Phase == urlshortenerv1.PhaseActive:
+			return lookup{
+				link: storage.Link{
+					TargetURL:    shortURL.Spec.TargetURL,
+					RedirectType: int(shortURL.Spec.RedirectType),
+					PathMetrics:  pathMetrics,
+					Owners:       owners,
+				},
+				found: true,
+			}, nil
+		}
+	}
+	return result, nil
+}
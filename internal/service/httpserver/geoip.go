@@ -0,0 +1,44 @@
+package httpserver
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP resolves client addresses to countries from an offline MaxMind DB file, such as GeoLite2
+// Country or City, or the DB-IP lite databases
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+// OpenGeoIP opens the database at path. It is memory mapped, so lookups don't read the file.
+func OpenGeoIP(path string) (*GeoIP, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// country returns the ISO 3166-1 alpha-2 code of the country of ip
+func (g *GeoIP) country(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return unknown
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := g.reader.Lookup(addr, &record); err != nil || record.Country.ISOCode == "" {
+		return unknown
+	}
+	return record.Country.ISOCode
+}
+
+// Close unmaps the database
+func (g *GeoIP) Close() error {
+	return g.reader.Close()
+}
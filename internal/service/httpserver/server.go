@@ -2,25 +2,91 @@ package httpserver
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
-	redisHandler "github.com/abexamir/url-shortener-operator/internal/service/redis"
-	"github.com/go-redis/redis/v8"
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+	"github.com/abexamir/url-shortener-operator/internal/service/events"
+	"github.com/abexamir/url-shortener-operator/internal/service/metrics"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// Options configure the HTTP server of the RedirectServer
+type Options struct {
+	// BindAddress is the address to listen on, e.g. ":8082"
+	BindAddress  string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout bounds how long in-flight requests are drained on shutdown
+	ShutdownTimeout time.Duration
+
+	// CacheSize is the number of short paths kept in the in-process cache, 0 disables it
+	CacheSize int
+	// CacheTTL bounds how long a link is served from the cache if its invalidation is missed
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long unknown and expired paths are cached
+	NegativeCacheTTL time.Duration
+
+	// ClickQueueSize bounds the clicks waiting to be counted, further clicks are dropped
+	ClickQueueSize int
+	// ClickFlushInterval is how often the aggregated clicks are written to storage
+	ClickFlushInterval time.Duration
+	// TrustForwardedFor takes the client address from X-Forwarded-For, for servers behind a proxy
+	// that sets it
+	TrustForwardedFor bool
+
+	// ClickStream appends an event per click to the click event stream of storage
+	ClickStream bool
+
+	// StatsTokens are the bearer tokens accepted by the statistics API, which is disabled if empty
+	StatsTokens []string
+}
+
+// RedirectServer serves the short paths. It is a manager.Runnable that runs on every replica,
+// leader or not.
 type RedirectServer struct {
-	redisService *redisHandler.RedisService
+	storage storage.Storage
+	options Options
+	// cache is nil if caching is disabled
+	cache  *linkCache
+	clicks *clickCounter
+	// fallback is consulted while storage is unavailable, nil if degraded mode is disabled
+	fallback *ShortURLIndex
+	// serving is set while the listener accepts connections
+	serving atomic.Bool
 }
 
-func NewRedirectServer(redisService *redisHandler.RedisService) *RedirectServer {
-	return &RedirectServer{
-		redisService: redisService,
+func NewRedirectServer(store storage.Storage, options Options) *RedirectServer {
+	s := &RedirectServer{
+		storage: store,
+		options: options,
+		clicks:  newClickCounter(store, options.ClickQueueSize, options.ClickFlushInterval),
+	}
+	if stream, ok := store.(storage.ClickEventStream); ok && options.ClickStream {
+		s.clicks.stream = stream
 	}
+	if options.CacheSize > 0 {
+		s.cache = newLinkCache(options.CacheSize, options.CacheTTL, options.NegativeCacheTTL)
+	}
+	return s
 }
 
 func (s *RedirectServer) HandleRedirect(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	start := time.Now()
+	code := s.handleRedirect(w, r, start)
+	metrics.RedirectDuration.WithLabelValues(strconv.Itoa(code)).Observe(time.Since(start).Seconds())
+}
+
+// handleRedirect answers the request and returns the status code it answered with
+func (s *RedirectServer) handleRedirect(w http.ResponseWriter, r *http.Request, start time.Time) int {
+	ctx := r.Context()
 	log := ctrllog.FromContext(ctx, "component", "redirect-server")
 
 	shortPath := r.URL.Path
@@ -29,41 +95,209 @@ func (s *RedirectServer) HandleRedirect(w http.ResponseWriter, r *http.Request)
 		"method", r.Method,
 		"remoteAddr", r.RemoteAddr)
 
-	targetURL, err := s.redisService.GetURL(ctx, shortPath)
-	if err == redis.Nil {
-		log.Info("Short path not found", "path", shortPath)
-		http.NotFound(w, r)
-		return
-	}
+	result, err := s.resolve(ctx, shortPath)
 	if err != nil {
 		log.Error(err, "Failed to retrieve target URL")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError
 	}
+	if result.expired {
+		log.Info("Short path has expired", "path", shortPath)
+		http.Error(w, "Link has expired", http.StatusGone)
+		return http.StatusGone
+	}
+	if !result.found {
+		log.Info("Short path not found", "path", shortPath)
+		metrics.RedirectNotFound.Inc()
+		http.NotFound(w, r)
+		return http.StatusNotFound
+	}
+	link := result.link
 
-	if err := s.redisService.IncrementClickCount(ctx, shortPath); err != nil {
-		log.Error(err, "Failed to increment click count",
-			"shortPath", shortPath)
-		// Continue with redirect despite click count error
+	s.clicks.record(click{
+		time:      start,
+		shortPath: shortPath,
+		owners:    link.Owners,
+		clientIP:  clientIP(r, s.options.TrustForwardedFor),
+		userAgent: r.UserAgent(),
+		referrer:  r.Referer(),
+	})
+	// Every labelled path is a time series of its own, so it's up to the ShortURL to opt in
+	pathLabel := ""
+	if link.PathMetrics {
+		pathLabel = shortPath
 	}
+	metrics.RedirectCount.WithLabelValues(pathLabel).Inc()
 
+	code := redirectStatus(link.RedirectType)
 	log.Info("Redirecting",
 		"shortPath", shortPath,
-		"targetURL", targetURL)
-	http.Redirect(w, r, targetURL, http.StatusFound)
+		"targetURL", link.TargetURL,
+		"status", code)
+	http.Redirect(w, r, link.TargetURL, code)
+	return code
 }
 
-func (s *RedirectServer) Start() error {
-	log := ctrllog.Log.WithName("redirect-server")
-	log.Info("Starting HTTP server on :8082")
+// WithFallback makes the server resolve short paths from index while storage is unavailable
+func (s *RedirectServer) WithFallback(index *ShortURLIndex) *RedirectServer {
+	s.fallback = index
+	return s
+}
+
+// WithGeoIP breaks clicks down by the country geoIP resolves the client address to
+func (s *RedirectServer) WithGeoIP(geoIP *GeoIP) *RedirectServer {
+	s.clicks.geoIP = geoIP
+	return s
+}
+
+// WithEvents publishes an event per click to publisher
+func (s *RedirectServer) WithEvents(publisher *events.Publisher) *RedirectServer {
+	s.clicks.publisher = publisher
+	return s
+}
+
+// resolve looks shortPath up in the cache, falling back to storage and, if that fails, to the
+// ShortURL index
+func (s *RedirectServer) resolve(ctx context.Context, shortPath string) (lookup, error) {
+	var generation uint64
+	if s.cache != nil {
+		if result, ok := s.cache.get(shortPath); ok {
+			return result, nil
+		}
+		generation = s.cache.currentGeneration()
+	}
+
+	var result lookup
+	link, err := s.storage.GetLink(ctx, shortPath)
+	switch {
+	case err == nil:
+		result = lookup{link: link, found: true}
+	case errors.Is(err, storage.ErrNotFound):
+		expired, err := s.storage.IsExpired(ctx, shortPath)
+		if err != nil {
+			metrics.StorageErrors.WithLabelValues("is_expired").Inc()
+			// Answer 404 rather than failing, but don't remember it
+			ctrllog.FromContext(ctx).Error(err, "Failed to check link expiration", "path", shortPath)
+			return lookup{}, nil
+		}
+		result = lookup{expired: expired}
+	default:
+		metrics.StorageErrors.WithLabelValues("get_link").Inc()
+		if s.fallback == nil {
+			return lookup{}, err
+		}
+		result, fallbackErr := s.fallback.resolve(ctx, shortPath)
+		if fallbackErr != nil {
+			return lookup{}, errors.Join(err, fallbackErr)
+		}
+		// Serve the last known state, but don't cache it so storage is asked again next time
+		ctrllog.FromContext(ctx).Info("Storage unavailable, resolved short path from ShortURLs",
+			"path", shortPath, "error", err.Error())
+		return result, nil
+	}
+
+	if s.cache != nil {
+		s.cache.add(shortPath, result, generation)
+	}
+	return result, nil
+}
+
+// redirectStatus returns the status code to redirect with, falling back to the configured
+// default and then to 302 Found if a code isn't a supported redirect
+func redirectStatus(redirectType int) int {
+	for _, code := range []int{redirectType, constants.DefaultRedirectType} {
+		switch code {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			return code
+		}
+	}
+	return http.StatusFound
+}
 
+// handler routes requests to the redirect handler and, if tokens are configured, the statistics API
+func (s *RedirectServer) handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.HandleRedirect)
+	if len(s.options.StatsTokens) > 0 {
+		s.registerStatsAPI(mux)
+	}
+	return mux
+}
+
+// Start serves redirects until ctx is cancelled, then stops accepting connections and waits for
+// in-flight requests to finish for up to ShutdownTimeout.
+func (s *RedirectServer) Start(ctx context.Context) error {
+	log := ctrllog.Log.WithName("redirect-server")
 
 	server := &http.Server{
-		Addr:    ":8082",
-		Handler: mux,
+		Addr:         s.options.BindAddress,
+		Handler:      s.handler(),
+		ReadTimeout:  s.options.ReadTimeout,
+		WriteTimeout: s.options.WriteTimeout,
+		IdleTimeout:  s.options.IdleTimeout,
+	}
+
+	listener, err := net.Listen("tcp", s.options.BindAddress)
+	if err != nil {
+		return err
+	}
+
+	if watcher, ok := s.storage.(storage.Watcher); ok && s.cache != nil {
+		go func() {
+			if err := watcher.WatchChanges(ctx, s.cache.invalidate); err != nil {
+				log.Error(err, "Stopped watching for changes, cached links expire after their TTL")
+			}
+		}()
+	}
+
+	// Count clicks until the last in-flight request is done
+	clicksCtx, stopClicks := context.WithCancel(context.Background())
+	clicksDone := make(chan struct{})
+	go func() {
+		s.clicks.run(clicksCtx)
+		close(clicksDone)
+	}()
+	defer func() {
+		stopClicks()
+		<-clicksDone
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info("Starting HTTP server", "addr", listener.Addr().String())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+	s.serving.Store(true)
+
+	select {
+	case err := <-errCh:
+		s.serving.Store(false)
+		return err
+	case <-ctx.Done():
 	}
 
-	return server.ListenAndServe()
+	s.serving.Store(false)
+	log.Info("Shutting down HTTP server, draining in-flight requests", "timeout", s.options.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.options.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain HTTP server: %w", err)
+	}
+	return <-errCh
+}
+
+// ReadyCheck is a healthz.Checker that fails unless the server accepts connections
+func (s *RedirectServer) ReadyCheck(_ *http.Request) error {
+	if !s.serving.Load() {
+		return errors.New("redirect server is not serving")
+	}
+	return nil
+}
+
+// NeedLeaderElection makes the manager run the redirect server on all replicas
+func (s *RedirectServer) NeedLeaderElection() bool {
+	return false
 }
@@ -0,0 +1,109 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+func TestHandleRedirect(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	links := map[string]storage.Link{
+		"/default": {TargetURL: "https://example.com/default"},
+	}
+	for shortPath, link := range links {
+		if err := store.ShareURL(ctx, shortPath, "default/"+shortPath[1:], link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := NewRedirectServer(store, Options{ClickQueueSize: 10, ClickFlushInterval: time.Second})
+
+	tests := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{path: "/default", code: http.StatusFound, location: "https://example.com/default"},
+		{path: "/unknown", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.HandleRedirect(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if recorder.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, recorder.Code)
+			}
+			if location := recorder.Header().Get("Location"); location != tt.location {
+				t.Errorf("expected location %q, got %q", tt.location, location)
+			}
+		})
+	}
+}
+
+func TestHandleRedirectExpired(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	link := storage.Link{TargetURL: "https://example.com/expired", TTL: time.Millisecond}
+	if err := store.ShareURL(ctx, "/expired", "default/expired", link); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	server := NewRedirectServer(store, Options{ClickQueueSize: 10, ClickFlushInterval: time.Second})
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{path: "/expired", code: http.StatusGone},
+		{path: "/unknown", code: http.StatusNotFound},
+	} {
+		recorder := httptest.NewRecorder()
+		server.HandleRedirect(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if recorder.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.code, recorder.Code)
+		}
+	}
+}
+
+func TestHandleRedirectType(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	links := map[string]storage.Link{
+		"/permanent": {TargetURL: "https://example.com/permanent", RedirectType: http.StatusMovedPermanently},
+		"/temporary": {TargetURL: "https://example.com/temporary", RedirectType: http.StatusTemporaryRedirect},
+		"/keep":      {TargetURL: "https://example.com/keep", RedirectType: http.StatusPermanentRedirect},
+		"/invalid":   {TargetURL: "https://example.com/invalid", RedirectType: http.StatusOK},
+	}
+	for shortPath, link := range links {
+		if err := store.ShareURL(ctx, shortPath, "default/"+shortPath[1:], link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := NewRedirectServer(store, Options{ClickQueueSize: 10, ClickFlushInterval: time.Second})
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{path: "/permanent", code: http.StatusMovedPermanently},
+		{path: "/temporary", code: http.StatusTemporaryRedirect},
+		{path: "/keep", code: http.StatusPermanentRedirect},
+		// Unsupported codes fall back to the default
+		{path: "/invalid", code: http.StatusFound},
+	} {
+		recorder := httptest.NewRecorder()
+		server.HandleRedirect(recorder, httptest.NewRequest(http.MethodPost, tt.path, nil))
+		if recorder.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.code, recorder.Code)
+		}
+		if location := recorder.Header().Get("Location"); location != links[tt.path].TargetURL {
+			t.Errorf("%s: expected location %q, got %q", tt.path, links[tt.path].TargetURL, location)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// StatsAPIPrefix is where the statistics API is served. Short paths are a single path segment, so
+// nothing under it can be a short path.
+const StatsAPIPrefix = "/_api/"
+
+// Defaults and limits of the statistics API query parameters
+const (
+	defaultStatsHours = 24
+	defaultStatsDays  = 7
+	defaultStatsTop   = 10
+	maxStatsTop       = 100
+)
+
+// linkStats is the response of the statistics API
+type linkStats struct {
+	ShortPath      string                                         `json:"shortPath"`
+	TargetURL      string                                         `json:"targetURL,omitempty"`
+	ClickCount     int64                                          `json:"clickCount"`
+	UniqueVisitors int64                                          `json:"uniqueVisitors"`
+	Series         map[storage.Resolution][]storage.ClickBucket   `json:"series"`
+	Breakdowns     map[storage.Dimension][]storage.BreakdownEntry `json:"breakdowns"`
+}
+
+// LoadStatsTokens reads the tokens allowed to use the statistics API from path, one per line.
+// Blank lines and lines starting with # are skipped.
+func LoadStatsTokens(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint:errcheck
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no tokens found")
+	}
+	return tokens, nil
+}
+
+// registerStatsAPI serves the statistics API on mux. Other paths under StatsAPIPrefix fall through
+// to the redirect handler, which answers 404 as they can't be short paths. A subtree pattern would
+// redirect a generated "/_api" short path instead.
+func (s *RedirectServer) registerStatsAPI(mux *http.ServeMux) {
+	mux.Handle("GET "+StatsAPIPrefix+"v1/links/{path}/stats", s.authorizeStats(http.HandlerFunc(s.HandleStats)))
+}
+
+// authorizeStats only lets requests with one of the configured bearer tokens through
+func (s *RedirectServer) authorizeStats(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.validStatsToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="url-shortener"`)
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *RedirectServer) validStatsToken(token string) bool {
+	valid := 0
+	for _, allowed := range s.options.StatsTokens {
+		valid |= subtle.ConstantTimeCompare([]byte(token), []byte(allowed))
+	}
+	return token != "" && valid == 1
+}
+
+// HandleStats returns the live statistics of the short path in the {path} wildcard, straight from
+// storage. The hours, days and top query parameters select how many hourly and daily buckets and
+// how many values per breakdown are returned.
+func (s *RedirectServer) HandleStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := ctrllog.FromContext(ctx, "component", "stats-api")
+
+	shortPath := "/" + r.PathValue("path")
+	hours, err := intParam(r, "hours", defaultStatsHours, storage.ResolutionHour.Buckets())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	days, err := intParam(r, "days", defaultStatsDays, storage.ResolutionDay.Buckets())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	top, err := intParam(r, "top", defaultStatsTop, maxStatsTop)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stats, err := s.linkStats(r, shortPath, hours, days, top)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "short path not found")
+		return
+	}
+	if err != nil {
+		log.Error(err, "Failed to read link statistics", "path", shortPath)
+		writeJSONError(w, http.StatusInternalServerError, "failed to read statistics")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// linkStats collects the statistics of shortPath. Paths that no longer resolve still have
+// statistics as long as they had clicks, storage.ErrNotFound is returned otherwise.
+func (s *RedirectServer) linkStats(r *http.Request, shortPath string, hours, days, top int) (*linkStats, error) {
+	ctx := r.Context()
+	stats := &linkStats{
+		ShortPath:  shortPath,
+		Series:     map[storage.Resolution][]storage.ClickBucket{},
+		Breakdowns: map[storage.Dimension][]storage.BreakdownEntry{},
+	}
+
+	link, err := s.storage.GetLink(ctx, shortPath)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	stats.TargetURL = link.TargetURL
+	if stats.ClickCount, err = s.storage.GetClickCount(ctx, shortPath); err != nil {
+		return nil, err
+	}
+	if stats.TargetURL == "" && stats.ClickCount == 0 {
+		return nil, storage.ErrNotFound
+	}
+	if stats.UniqueVisitors, err = s.storage.GetUniqueVisitors(ctx, shortPath); err != nil {
+		return nil, err
+	}
+	for resolution, n := range map[storage.Resolution]int{storage.ResolutionHour: hours, storage.ResolutionDay: days} {
+		if stats.Series[resolution], err = s.storage.GetClickSeries(ctx, shortPath, resolution, n); err != nil {
+			return nil, err
+		}
+	}
+	for _, dimension := range storage.Dimensions {
+		if stats.Breakdowns[dimension], err = s.storage.GetBreakdown(ctx, shortPath, dimension, top); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// intParam parses the query parameter name as an integer between 1 and limit
+func intParam(r *http.Request, name string, fallback, limit int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return min(fallback, limit), nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > limit {
+		return 0, errors.New(name + " must be between 1 and " + strconv.Itoa(limit))
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
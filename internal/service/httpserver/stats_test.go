@@ -0,0 +1,193 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+const testStatsToken = "secret"
+
+// newStatsServer returns a redirect server with the statistics API enabled for testStatsToken, serving
+// /abc with a few recorded clicks
+func newStatsServer(t *testing.T) http.Handler {
+	t.Helper()
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	if err := store.ShareURL(ctx, "/abc", "default/a", storage.Link{TargetURL: "https://example.com/abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddClicks(ctx, map[string]*storage.ClickBatch{"/abc": {
+		Count:    3,
+		Visitors: map[string]struct{}{"v1": {}, "v2": {}},
+		Breakdowns: map[storage.Dimension]map[string]int64{
+			storage.DimensionBrowser: {"Chrome": 2, "Firefox": 1},
+		},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	server := NewRedirectServer(store, Options{
+		ClickQueueSize:     10,
+		ClickFlushInterval: time.Second,
+		StatsTokens:        []string{"other", testStatsToken},
+	})
+	return server.handler()
+}
+
+func getStats(handler http.Handler, target, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestStatsAPIAuthorization(t *testing.T) {
+	handler := newStatsServer(t)
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{name: "missing token", code: http.StatusUnauthorized},
+		{name: "wrong token", token: "wrong", code: http.StatusUnauthorized},
+		{name: "valid token", token: testStatsToken, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := getStats(handler, "/_api/v1/links/abc/stats", tt.token)
+			if recorder.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, recorder.Code)
+			}
+			if tt.code == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestStatsAPIDisabled(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	if err := store.ShareURL(context.Background(), "/abc", "default/a", storage.Link{TargetURL: "https://example.com/abc"}); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewRedirectServer(store, Options{ClickQueueSize: 10, ClickFlushInterval: time.Second}).handler()
+
+	// Without tokens the API isn't served, the path is left to the redirect handler
+	for _, token := range []string{"", testStatsToken} {
+		if recorder := getStats(handler, "/_api/v1/links/abc/stats", token); recorder.Code != http.StatusNotFound {
+			t.Errorf("token %q: expected %d, got %d", token, http.StatusNotFound, recorder.Code)
+		}
+	}
+}
+
+func TestStatsAPIParams(t *testing.T) {
+	handler := newStatsServer(t)
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{query: "", code: http.StatusOK},
+		{query: "?hours=1&days=1&top=1", code: http.StatusOK},
+		{query: "?hours=" + strconv.Itoa(storage.ResolutionHour.Buckets()), code: http.StatusOK},
+		{query: "?hours=0", code: http.StatusBadRequest},
+		{query: "?hours=-1", code: http.StatusBadRequest},
+		{query: "?hours=" + strconv.Itoa(storage.ResolutionHour.Buckets()+1), code: http.StatusBadRequest},
+		{query: "?days=" + strconv.Itoa(storage.ResolutionDay.Buckets()+1), code: http.StatusBadRequest},
+		{query: "?top=101", code: http.StatusBadRequest},
+		{query: "?hours=abc", code: http.StatusBadRequest},
+		{query: "?days=1.5", code: http.StatusBadRequest},
+		{query: "?top=ten", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		recorder := getStats(handler, "/_api/v1/links/abc/stats"+tt.query, testStatsToken)
+		if recorder.Code != tt.code {
+			t.Errorf("%q: expected %d, got %d", tt.query, tt.code, recorder.Code)
+		}
+		if tt.code == http.StatusBadRequest {
+			var body map[string]string
+			if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil || body["error"] == "" {
+				t.Errorf("%q: expected an error message, got %q", tt.query, recorder.Body.String())
+			}
+		}
+	}
+}
+
+func TestStatsAPIUnknownPath(t *testing.T) {
+	handler := newStatsServer(t)
+
+	if recorder := getStats(handler, "/_api/v1/links/unknown/stats", testStatsToken); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestStatsAPIBody(t *testing.T) {
+	handler := newStatsServer(t)
+
+	recorder := getStats(handler, "/_api/v1/links/abc/stats?hours=3&days=2&top=1", testStatsToken)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	var stats linkStats
+	if err := json.NewDecoder(recorder.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ShortPath != "/abc" || stats.TargetURL != "https://example.com/abc" {
+		t.Errorf("unexpected link %q -> %q", stats.ShortPath, stats.TargetURL)
+	}
+	if stats.ClickCount != 3 || stats.UniqueVisitors != 2 {
+		t.Errorf("expected 3 clicks by 2 visitors, got %d by %d", stats.ClickCount, stats.UniqueVisitors)
+	}
+	hours := stats.Series[storage.ResolutionHour]
+	var hourlyClicks int64
+	for _, bucket := range hours {
+		hourlyClicks += bucket.Count
+	}
+	if len(hours) != 3 || hourlyClicks != 3 {
+		t.Errorf("expected 3 hourly buckets with the clicks, got %+v", hours)
+	}
+	if days := stats.Series[storage.ResolutionDay]; len(days) != 2 {
+		t.Errorf("expected 2 daily buckets, got %+v", days)
+	}
+	expected := []storage.BreakdownEntry{{Value: "Chrome", Count: 2}}
+	if browsers := stats.Breakdowns[storage.DimensionBrowser]; !slices.Equal(browsers, expected) {
+		t.Errorf("expected browsers %+v, got %+v", expected, browsers)
+	}
+}
+
+func TestLoadStatsTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# stats readers\nfirst\n\n  second  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadStatsTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tokens, []string{"first", "second"}) {
+		t.Errorf("unexpected tokens %q", tokens)
+	}
+
+	if err := os.WriteFile(path, []byte("# none\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadStatsTokens(path); err == nil {
+		t.Error("expected an error for a file without tokens")
+	}
+}
@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"net/url"
+	"strings"
+)
+
+// unknown is recorded for clicks whose value of a dimension can't be determined
+const unknown = "Unknown"
+
+// userAgentFamily pairs a family with the user agent token that identifies it. Tokens are checked
+// in order, since many user agents claim to be others as well, e.g. Edge claims to be Chrome and
+// Safari. They match case-insensitively anywhere in the user agent unless caseSensitive or word
+// are set.
+type userAgentFamily struct {
+	token  string
+	family string
+	// caseSensitive matches the token as written
+	caseSensitive bool
+	// word only matches the token if it isn't part of a longer word
+	word bool
+}
+
+var browserFamilies = []userAgentFamily{
+	// Crawlers name themselves "Googlebot/2.1", "bingbot/2.0" and so on. Phone models like Cubot
+	// contain "bot" too.
+	{token: "bot/", family: "Bot"},
+	{token: "bot;", family: "Bot"},
+	{token: "bot", family: "Bot", word: true},
+	{token: "spider", family: "Bot"},
+	{token: "crawl", family: "Bot"},
+	{token: "curl/", family: "curl"},
+	{token: "wget/", family: "Wget"},
+	{token: "edg/", family: "Edge"},
+	{token: "edga/", family: "Edge"},
+	{token: "edgios/", family: "Edge"},
+	{token: "opr/", family: "Opera"},
+	{token: "opera", family: "Opera"},
+	{token: "samsungbrowser/", family: "Samsung Internet"},
+	{token: "yabrowser/", family: "Yandex Browser"},
+	{token: "firefox/", family: "Firefox"},
+	{token: "fxios/", family: "Firefox"},
+	{token: "crios/", family: "Chrome"},
+	{token: "chromium/", family: "Chromium"},
+	{token: "chrome/", family: "Chrome"},
+	{token: "safari/", family: "Safari"},
+	{token: "msie ", family: "Internet Explorer"},
+	{token: "trident/", family: "Internet Explorer"},
+}
+
+var osFamilies = []userAgentFamily{
+	{token: "android", family: "Android"},
+	{token: "iphone", family: "iOS"},
+	{token: "ipad", family: "iOS"},
+	{token: "ipod", family: "iOS"},
+	{token: "windows", family: "Windows"},
+	// "cros" is part of "Microsoft"
+	{token: "CrOS ", family: "ChromeOS", caseSensitive: true},
+	{token: "mac os x", family: "macOS"},
+	{token: "macintosh", family: "macOS"},
+	{token: "linux", family: "Linux"},
+}
+
+// browserFamily returns the browser family of a User-Agent header
+func browserFamily(userAgent string) string {
+	return matchFamily(userAgent, browserFamilies)
+}
+
+// osFamily returns the operating system family of a User-Agent header
+func osFamily(userAgent string) string {
+	return matchFamily(userAgent, osFamilies)
+}
+
+func matchFamily(userAgent string, families []userAgentFamily) string {
+	lower := strings.ToLower(userAgent)
+	for _, f := range families {
+		s := lower
+		if f.caseSensitive {
+			s = userAgent
+		}
+		if containsToken(s, f.token, f.word) {
+			return f.family
+		}
+	}
+	return unknown
+}
+
+// containsToken reports whether s contains token, and if word is set, an occurrence of it that
+// isn't preceded or followed by a letter or digit
+func containsToken(s, token string, word bool) bool {
+	for offset := 0; ; {
+		i := strings.Index(s[offset:], token)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(token)
+		if !word || !isWordByte(s, start-1) && !isWordByte(s, end) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// isWordByte reports whether s has a letter or digit at i
+func isWordByte(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// referrerHost returns the host of a Referer header, or "Direct" if there is none
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return "Direct"
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return unknown
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
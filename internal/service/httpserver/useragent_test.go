@@ -0,0 +1,98 @@
+package httpserver
+
+import "testing"
+
+func TestUserAgentFamilies(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		browser   string
+		os        string
+	}{
+		{
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) " +
+				"Chrome/124.0.0.0 Safari/537.36",
+			browser: "Chrome", os: "Windows",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) " +
+				"Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.80",
+			browser: "Edge", os: "Windows",
+		},
+		{
+			userAgent: "Microsoft Office/16.0 (Windows NT 10.0; Microsoft Outlook 16.0.17425; Pro)",
+			browser:   unknown, os: "Windows",
+		},
+		{
+			userAgent: "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) " +
+				"Chrome/124.0.0.0 Safari/537.36",
+			browser: "Chrome", os: "ChromeOS",
+		},
+		{
+			userAgent: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			browser:   "Firefox", os: "Linux",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) " +
+				"Version/17.4.1 Safari/605.1.15",
+			browser: "Safari", os: "macOS",
+		},
+		{
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4_1 like Mac OS X) AppleWebKit/605.1.15 " +
+				"(KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			browser: "Chrome", os: "iOS",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) " +
+				"SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			browser: "Samsung Internet", os: "Android",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 10; Cubot KingKong 5 Pro) AppleWebKit/537.36 " +
+				"(KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36",
+			browser: "Chrome", os: "Android",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 " +
+				"(KHTML, like Gecko) Chrome/124.0.6367.201 Mobile Safari/537.36 " +
+				"(compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			browser: "Bot", os: "Android",
+		},
+		{
+			userAgent: "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+			browser:   "Bot", os: unknown,
+		},
+		{
+			userAgent: "Mozilla/5.0 (compatible; Discordbot; +https://discordapp.com)",
+			browser:   "Bot", os: unknown,
+		},
+		{
+			userAgent: "Mozilla/5.0 (compatible; Telegram Bot)",
+			browser:   "Bot", os: unknown,
+		},
+		{userAgent: "curl/8.5.0", browser: "curl", os: unknown},
+		{userAgent: "", browser: unknown, os: unknown},
+	}
+	for _, tt := range tests {
+		if browser := browserFamily(tt.userAgent); browser != tt.browser {
+			t.Errorf("%q: expected browser %q, got %q", tt.userAgent, tt.browser, browser)
+		}
+		if os := osFamily(tt.userAgent); os != tt.os {
+			t.Errorf("%q: expected OS %q, got %q", tt.userAgent, tt.os, os)
+		}
+	}
+}
+
+func TestReferrerHost(t *testing.T) {
+	tests := map[string]string{
+		"":                              "Direct",
+		"https://www.Google.com/search": "google.com",
+		"https://t.co/abc":              "t.co",
+		"android-app://org.telegram":    "org.telegram",
+		"not a url":                     unknown,
+	}
+	for referrer, expected := range tests {
+		if host := referrerHost(referrer); host != expected {
+			t.Errorf("%q: expected %q, got %q", referrer, expected, host)
+		}
+	}
+}
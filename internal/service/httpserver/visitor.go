@@ -0,0 +1,44 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client, taken from the first X-Forwarded-For entry if
+// trustForwardedFor is set and the header is present
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// anonymizeIP clears the host bits of ip, keeping the /24 network of IPv4 and the /48 network of
+// IPv6 addresses. Addresses that can't be parsed are dropped.
+func anonymizeIP(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return addr.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// visitorFingerprint identifies a client by its address and user agent without keeping either
+func visitorFingerprint(ip, userAgent string) string {
+	hash := sha256.Sum256([]byte(ip + "\n" + userAgent))
+	return hex.EncodeToString(hash[:16])
+}
@@ -9,20 +9,99 @@ var (
 	RedirectCount = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "url_shortener_redirects_total",
-			Help: "Number of redirects performed by the URL shortener",
+			Help: "Number of redirects performed by the URL shortener. short_path is empty unless the ShortURL opted in.",
 		},
 		[]string{"short_path"},
 	)
 
+	RedirectDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "url_shortener_redirect_duration_seconds",
+			Help:    "Time taken to answer redirect requests, by response status code",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		},
+		[]string{"code"},
+	)
+
+	RedirectNotFound = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "url_shortener_redirect_not_found_total",
+			Help: "Number of redirect requests for unknown short paths",
+		},
+	)
+
+	StorageErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "url_shortener_storage_errors_total",
+			Help: "Number of failed storage operations on the redirect path",
+		},
+		[]string{"operation"},
+	)
+
+	RedisOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "url_shortener_redis_operation_duration_seconds",
+			Help:    "Latency of Redis commands, or of whole pipelines",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1},
+		},
+		[]string{"operation"},
+	)
+
 	ReconcileErrors = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "url_shortener_reconcile_errors_total",
 			Help: "Number of reconciliation errors",
 		},
 	)
+
+	ClicksFlushed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "url_shortener_clicks_flushed_total",
+			Help: "Number of clicks written to storage",
+		},
+	)
+
+	ClicksDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "url_shortener_clicks_dropped_total",
+			Help: "Number of clicks dropped because the click queue was full or storage was unavailable",
+		},
+	)
+
+	ClickEventsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "url_shortener_click_events_dropped_total",
+			Help: "Number of click events not appended to the stream because storage was unavailable",
+		},
+	)
+
+	EventsPublished = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "url_shortener_events_published_total",
+			Help: "Number of events delivered, by sink",
+		},
+		[]string{"sink"},
+	)
+
+	EventsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "url_shortener_events_dropped_total",
+			Help: "Number of events dropped because the queue of the sink was full or delivery failed, by sink",
+		},
+		[]string{"sink"},
+	)
 )
 
 func init() {
 	metrics.Registry.MustRegister(RedirectCount)
+	metrics.Registry.MustRegister(RedirectDuration)
+	metrics.Registry.MustRegister(RedirectNotFound)
+	metrics.Registry.MustRegister(StorageErrors)
+	metrics.Registry.MustRegister(RedisOperationDuration)
 	metrics.Registry.MustRegister(ReconcileErrors)
+	metrics.Registry.MustRegister(ClicksFlushed)
+	metrics.Registry.MustRegister(ClicksDropped)
+	metrics.Registry.MustRegister(ClickEventsDropped)
+	metrics.Registry.MustRegister(EventsPublished)
+	metrics.Registry.MustRegister(EventsDropped)
 }
@@ -0,0 +1,42 @@
+package redisHandler
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/abexamir/url-shortener-operator/internal/service/metrics"
+)
+
+type startKey struct{}
+
+// durationHook records the latency of every command, labelled by its name, and of every
+// pipeline as a whole
+type durationHook struct{}
+
+var _ redis.Hook = durationHook{}
+
+func (durationHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (durationHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observe(ctx, cmd.Name())
+	return nil
+}
+
+func (durationHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (durationHook) AfterProcessPipeline(ctx context.Context, _ []redis.Cmder) error {
+	observe(ctx, "pipeline")
+	return nil
+}
+
+func observe(ctx context.Context, operation string) {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		metrics.RedisOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,152 @@
+package redisHandler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis deployments supported by NewRedisService
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Options configure the connection to Redis
+type Options struct {
+	// Mode is one of ModeStandalone, ModeSentinel or ModeCluster
+	Mode string
+	// Addrs holds the server address in standalone mode, the sentinel addresses in sentinel mode
+	// and the seed nodes in cluster mode
+	Addrs []string
+	// MasterName is the name of the master monitored by the sentinels
+	MasterName string
+	// DB is the database to select, it must be 0 in cluster mode
+	DB int
+
+	Username string
+	Password string
+	// SentinelUsername and SentinelPassword authenticate against the sentinels if they differ
+	// from the credentials of the data nodes
+	SentinelUsername string
+	SentinelPassword string
+
+	// TLS enables TLS when not nil
+	TLS *TLSOptions
+}
+
+// TLSOptions configure TLS for the Redis connection. File paths usually point into a mounted Secret.
+type TLSOptions struct {
+	// CAFile verifies the server certificate, the system roots are used if empty
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name checked against the server certificate
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// OptionsFromEnv returns the Options configured through the REDIS_* environment variables
+func OptionsFromEnv() Options {
+	opts := Options{
+		Mode:             constants.RedisMode,
+		Addrs:            constants.RedisAddrs,
+		MasterName:       constants.RedisSentinelMaster,
+		DB:               constants.RedisDB,
+		Username:         constants.RedisUsername,
+		Password:         constants.RedisPassword,
+		SentinelUsername: constants.RedisSentinelUsername,
+		SentinelPassword: constants.RedisSentinelPassword,
+	}
+	if constants.RedisTLSEnabled {
+		opts.TLS = &TLSOptions{
+			CAFile:             constants.RedisTLSCAFile,
+			CertFile:           constants.RedisTLSCertFile,
+			KeyFile:            constants.RedisTLSKeyFile,
+			ServerName:         constants.RedisTLSServerName,
+			InsecureSkipVerify: constants.RedisTLSInsecureSkipVerify,
+		}
+	}
+	return opts
+}
+
+// newClient builds the client matching opts.Mode
+func (opts Options) newClient() (redis.UniversalClient, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("no Redis address configured")
+	}
+	tlsConfig, err := opts.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Mode {
+	case ModeStandalone, "":
+		return redis.NewClient(&redis.Options{
+			Addr:      opts.Addrs[0],
+			DB:        opts.DB,
+			Username:  opts.Username,
+			Password:  opts.Password,
+			TLSConfig: tlsConfig,
+		}), nil
+	case ModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.Addrs,
+			SentinelUsername: opts.SentinelUsername,
+			SentinelPassword: opts.SentinelPassword,
+			DB:               opts.DB,
+			Username:         opts.Username,
+			Password:         opts.Password,
+			TLSConfig:        tlsConfig,
+		}), nil
+	case ModeCluster:
+		if opts.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports DB 0, got %d", opts.DB)
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     opts.Addrs,
+			Username:  opts.Username,
+			Password:  opts.Password,
+			TLSConfig: tlsConfig,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown Redis mode %q", opts.Mode)
+	}
+}
+
+func (t *TLSOptions) config() (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify, // nolint:gosec
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Redis client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
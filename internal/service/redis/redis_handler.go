@@ -2,47 +2,597 @@ package redisHandler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/abexamir/url-shortener-operator/internal/constants"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
 	"github.com/go-redis/redis/v8"
 )
 
+// linkHelpers is shared by the claim scripts. KEYS[1] is the short path, KEYS[2] its owner record,
+// KEYS[3] its redirect type and KEYS[4] the set of owners that opted in to path metrics; all of them
+// get the same lifetime, or are persistent when ttl is zero. Path metrics aren't part of the link,
+// so owners with different settings share a path.
+const linkHelpers = `
+local function setOrDel(key, value)
+	if value ~= '' then
+		redis.call('SET', key, value)
+	else
+		redis.call('DEL', key)
+	end
+end
+local function store(target, redirect, owner, pathMetrics)
+	redis.call('SET', KEYS[1], target)
+	setOrDel(KEYS[3], redirect)
+	if pathMetrics ~= '' then
+		redis.call('SADD', KEYS[4], owner)
+	else
+		redis.call('SREM', KEYS[4], owner)
+	end
+end
+local function sameLink(target, redirect)
+	return redis.call('GET', KEYS[1]) == target and (redis.call('GET', KEYS[3]) or '') == redirect
+end
+local function expire(ttl)
+	for i = 1, 4 do
+		if ttl > 0 then
+			redis.call('PEXPIRE', KEYS[i], ttl)
+		else
+			redis.call('PERSIST', KEYS[i])
+		end
+	end
+end
+`
+
+// shareScript maps the path to the link and adds the owner to its owner record, unless the path
+// resolves to a different link or is exclusively claimed by someone else. The mapping is kept alive
+// for as long as the longest-lived owner needs it. Returns that TTL in milliseconds (0 for none),
+// or -1 if the path can't be shared.
+// KEYS as in linkHelpers, ARGV[1] = target URL, ARGV[2] = owner, ARGV[3] = TTL in milliseconds
+// (0 for none), ARGV[4] = redirect type (empty for the server default), ARGV[5] = path metrics
+// flag (empty for off)
+var shareScript = redis.NewScript(linkHelpers + `
+if redis.call('EXISTS', KEYS[1]) == 1 and not sameLink(ARGV[1], ARGV[4]) then
+	return -1
+end
+local ttl = tonumber(ARGV[3])
+local pttl = redis.call('PTTL', KEYS[1])
+local owners = redis.call('HGETALL', KEYS[2])
+for i = 1, #owners, 2 do
+	if owners[i] ~= ARGV[2] then
+		if owners[i + 1] == '` + claimCustom + `' then
+			return -1
+		end
+		if pttl == -1 or ttl == 0 then
+			ttl = 0
+		elseif pttl > ttl then
+			ttl = pttl
+		end
+	end
+end
+store(ARGV[1], ARGV[4], ARGV[2], ARGV[5])
+redis.call('HSET', KEYS[2], ARGV[2], '` + claimHash + `')
+expire(ttl)
+return ttl
+`)
+
+// claimScript sets the path and its owner record only if nobody else owns the path.
+// KEYS and ARGV[1..5] as in shareScript, ARGV[6] = kind of claim
+var claimScript = redis.NewScript(linkHelpers + `
+for _, owner in ipairs(redis.call('HKEYS', KEYS[2])) do
+	if owner ~= ARGV[2] then
+		return owner
+	end
+end
+redis.call('DEL', KEYS[4])
+store(ARGV[1], ARGV[4], ARGV[2], ARGV[5])
+redis.call('HSET', KEYS[2], ARGV[2], ARGV[6])
+expire(tonumber(ARGV[3]))
+return ''
+`)
+
+// releaseScript removes the owner from the path's owner record and path metrics owners and, if it
+// was the last one, deletes the path together with its redirect type, expiry marker and click counter.
+// KEYS[1..4] as in linkHelpers, KEYS[5] = expired key, KEYS[6] = click count key, ARGV[1] = owner
+var releaseScript = redis.NewScript(`
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('SREM', KEYS[4], ARGV[1])
+if redis.call('HLEN', KEYS[2]) > 0 then
+	return 0
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5], KEYS[6])
+return 1
+`)
+
+// breakdownScript adds click counts to a breakdown hash. Values that aren't in the hash yet are
+// counted under the catch-all value once it holds the maximum number of values, as referrers are
+// set by clients and could otherwise grow it without bounds.
+// KEYS[1] = breakdown key, ARGV[1] = maximum number of values, ARGV[2] = catch-all value,
+// ARGV[3..] = pairs of value and click count
+var breakdownScript = redis.NewScript(`
+local max = tonumber(ARGV[1])
+local size = redis.call('HLEN', KEYS[1])
+for i = 3, #ARGV, 2 do
+	local value = ARGV[i]
+	if redis.call('HEXISTS', KEYS[1], value) == 0 then
+		if size >= max then
+			value = ARGV[2]
+		else
+			size = size + 1
+		end
+	end
+	redis.call('HINCRBY', KEYS[1], value, ARGV[i + 1])
+end
+return size
+`)
+
+// Kinds of claim recorded in the owner record of a short path
+const (
+	claimCustom = "custom"
+	claimHash   = "hash"
+)
+
+// ErrUnreachable is returned by NewRedisService if the configuration is fine but Redis doesn't answer
+var ErrUnreachable = errors.New("failed to connect to Redis")
+
 type RedisService struct {
-	client *redis.Client
+	client redis.UniversalClient
+	// hashTags wraps the short path of derived keys in a hash tag, so in cluster mode they land in
+	// the same slot as the path itself and can be used together in scripts
+	hashTags bool
 }
 
-func NewRedisService(addr string) (*RedisService, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+var (
+	_ storage.Storage = &RedisService{}
+	_ storage.Watcher = &RedisService{}
+)
+
+func NewRedisService(opts Options) (*RedisService, error) {
+	service, err := NewLazyRedisService(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := service.Ping(context.Background()); err != nil {
+		_ = service.client.Close()
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	return service, nil
+}
 
-	ctx := context.Background()
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+// NewLazyRedisService is like NewRedisService but doesn't wait for Redis to answer. Commands fail
+// until it does, the client connects on first use.
+func NewLazyRedisService(opts Options) (*RedisService, error) {
+	client, err := opts.newClient()
+	if err != nil {
+		return nil, err
 	}
 
-	return &RedisService{client: client}, nil
+	client.AddHook(durationHook{})
+	return &RedisService{client: client, hashTags: opts.Mode == ModeCluster}, nil
+}
+
+func (s *RedisService) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
 }
 
 func (s *RedisService) GetURL(ctx context.Context, shortPath string) (string, error) {
-	return s.client.Get(ctx, shortPath).Result()
+	targetURL, err := s.client.Get(ctx, shortPath).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", storage.ErrNotFound
+	}
+	return targetURL, err
 }
 
-func (s *RedisService) SetURL(ctx context.Context, shortPath, targetURL string) error {
-	return s.client.Set(ctx, shortPath, targetURL, -1).Err()
+// SetURL maps shortPath to targetURL. A ttl of zero means the mapping never expires.
+func (s *RedisService) SetURL(ctx context.Context, shortPath, targetURL string, ttl time.Duration) error {
+	if err := s.client.Set(ctx, shortPath, targetURL, ttl).Err(); err != nil {
+		return err
+	}
+	if err := s.markExpiry(ctx, shortPath, targetURL, ttl); err != nil {
+		return err
+	}
+	return s.publishChange(ctx, shortPath)
 }
 
 func (s *RedisService) DeleteURL(ctx context.Context, shortPath string) error {
-	return s.client.Del(ctx, shortPath).Err()
+	keys := []string{shortPath, s.redirectTypeKey(shortPath), s.pathMetricsKey(shortPath), s.expiredKey(shortPath)}
+	if err := s.client.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	return s.publishChange(ctx, shortPath)
+}
+
+// GetLink returns the link of shortPath with its remaining lifetime and owners, or storage.ErrNotFound
+// if it doesn't exist.
+func (s *RedisService) GetLink(ctx context.Context, shortPath string) (storage.Link, error) {
+	var mget *redis.SliceCmd
+	var pttl *redis.DurationCmd
+	var owners, metricsOwners *redis.StringSliceCmd
+	if _, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		mget = pipe.MGet(ctx, shortPath, s.redirectTypeKey(shortPath))
+		pttl = pipe.PTTL(ctx, shortPath)
+		owners = pipe.HKeys(ctx, s.ownerKey(shortPath))
+		metricsOwners = pipe.SMembers(ctx, s.pathMetricsKey(shortPath))
+		return nil
+	}); err != nil {
+		return storage.Link{}, err
+	}
+	values := mget.Val()
+	targetURL, ok := values[0].(string)
+	if !ok {
+		return storage.Link{}, storage.ErrNotFound
+	}
+	link := storage.Link{TargetURL: targetURL}
+	if redirectType, ok := values[1].(string); ok {
+		link.RedirectType, _ = strconv.Atoi(redirectType)
+	}
+	link.Owners = owners.Val()
+	slices.Sort(link.Owners)
+	link.PathMetricsOwners = metricsOwners.Val()
+	slices.Sort(link.PathMetricsOwners)
+	link.PathMetrics = len(link.PathMetricsOwners) > 0
+	if ttl := pttl.Val(); ttl > 0 {
+		link.TTL = ttl
+	}
+	return link, nil
+}
+
+// ShareURL atomically maps shortPath to link on behalf of owner. Several owners may share a path
+// as long as they resolve to the same link. storage.ErrPathConflict is returned if the path resolves
+// to another link or is exclusively claimed.
+func (s *RedisService) ShareURL(ctx context.Context, shortPath, owner string, link storage.Link) error {
+	ttl, err := shareScript.Run(ctx, s.client, s.linkKeys(shortPath), scriptArgs(owner, link)...).Int64()
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return fmt.Errorf("%w: %s points to another target", storage.ErrPathConflict, shortPath)
+	}
+	// The mapping lives as long as its longest-lived owner, so it expires at that owner's TTL
+	if err := s.markExpiry(ctx, shortPath, link.TargetURL, time.Duration(ttl)*time.Millisecond); err != nil {
+		return err
+	}
+	return s.publishChange(ctx, shortPath)
+}
+
+// ClaimURL atomically and exclusively maps shortPath to link on behalf of owner.
+// It returns storage.ErrPathConflict, naming the current owner, if the path belongs to someone else.
+func (s *RedisService) ClaimURL(ctx context.Context, shortPath, owner string, link storage.Link) error {
+	return s.claim(ctx, shortPath, owner, link, claimCustom)
+}
+
+// RetargetURL points a generated shortPath at a new link in place, keeping its click history.
+// This is only possible while owner is the sole owner of the path, storage.ErrPathConflict is returned
+// otherwise. Unlike ClaimURL the path stays open to ShortURLs sharing the new link.
+func (s *RedisService) RetargetURL(ctx context.Context, shortPath, owner string, link storage.Link) error {
+	return s.claim(ctx, shortPath, owner, link, claimHash)
+}
+
+func (s *RedisService) claim(ctx context.Context, shortPath, owner string, link storage.Link, kind string) error {
+	args := append(scriptArgs(owner, link), kind)
+	current, err := claimScript.Run(ctx, s.client, s.linkKeys(shortPath), args...).Text()
+	if err != nil {
+		return err
+	}
+	if current != "" {
+		return fmt.Errorf("%w: %s is owned by %s", storage.ErrPathConflict, shortPath, current)
+	}
+	if err := s.markExpiry(ctx, shortPath, link.TargetURL, link.TTL); err != nil {
+		return err
+	}
+	return s.publishChange(ctx, shortPath)
+}
+
+// ReleaseURL drops owner from shortPath. The mapping and its click counters are only deleted
+// once no owner is left.
+func (s *RedisService) ReleaseURL(ctx context.Context, shortPath, owner string) error {
+	keys := append(s.linkKeys(shortPath), s.expiredKey(shortPath), s.clickCountKey(shortPath))
+	deleted, err := releaseScript.Run(ctx, s.client, keys, owner).Int()
+	if err != nil {
+		return err
+	}
+	if deleted == 1 {
+		// The buckets expire on their own, but a new owner of the path shouldn't inherit them
+		keys := append(s.seriesKeys(shortPath), s.visitorsKey(shortPath))
+		keys = append(keys, s.breakdownKeys(shortPath)...)
+		if err := s.client.Del(ctx, keys...).Err(); err != nil {
+			return err
+		}
+	}
+	return s.publishChange(ctx, shortPath)
+}
+
+// GetOwners returns the owners (namespace/name) currently sharing shortPath.
+func (s *RedisService) GetOwners(ctx context.Context, shortPath string) ([]string, error) {
+	return s.client.HKeys(ctx, s.ownerKey(shortPath)).Result()
+}
+
+// ListShortPaths scans for path keys, owner records, click counters and visitor counts. In cluster mode every
+// master is scanned.
+func (s *RedisService) ListShortPaths(ctx context.Context) ([]string, error) {
+	found := map[string]struct{}{}
+	patterns := map[string]string{
+		"/*":                                "",
+		constants.OwnerKeyPrefix + "*":      constants.OwnerKeyPrefix,
+		constants.ClickCountKeyPrefix + "*": constants.ClickCountKeyPrefix,
+		constants.VisitorsKeyPrefix + "*":   constants.VisitorsKeyPrefix,
+	}
+	var mu sync.Mutex
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		for pattern, prefix := range patterns {
+			iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+			for iter.Next(ctx) {
+				shortPath := strings.TrimPrefix(iter.Val(), prefix)
+				if s.hashTags && prefix != "" {
+					shortPath = strings.TrimSuffix(strings.TrimPrefix(shortPath, "{"), "}")
+				}
+				mu.Lock()
+				found[shortPath] = struct{}{}
+				mu.Unlock()
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scan(ctx, master)
+		})
+	} else {
+		err = scan(ctx, s.client)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	shortPaths := make([]string, 0, len(found))
+	for shortPath := range found {
+		shortPaths = append(shortPaths, shortPath)
+	}
+	slices.Sort(shortPaths)
+	return shortPaths, nil
+}
+
+// PurgeURL deletes every key of shortPath regardless of its owners
+func (s *RedisService) PurgeURL(ctx context.Context, shortPath string) error {
+	keys := append(s.linkKeys(shortPath), s.expiredKey(shortPath), s.clickCountKey(shortPath), s.visitorsKey(shortPath))
+	keys = append(keys, s.seriesKeys(shortPath)...)
+	keys = append(keys, s.breakdownKeys(shortPath)...)
+	if err := s.client.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	return s.publishChange(ctx, shortPath)
+}
+
+// IsExpired reports whether shortPath used to exist but has passed its expiration time.
+func (s *RedisService) IsExpired(ctx context.Context, shortPath string) (bool, error) {
+	n, err := s.client.Exists(ctx, s.expiredKey(shortPath)).Result()
+	return n > 0, err
 }
 
 func (s *RedisService) GetClickCount(ctx context.Context, shortPath string) (int64, error) {
-	clickKey := fmt.Sprintf("%s%s", constants.ClickCountKeyPrefix, shortPath)
-	return s.client.Get(ctx, clickKey).Int64()
+	count, err := s.client.Get(ctx, s.clickCountKey(shortPath)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
 }
 
 func (s *RedisService) IncrementClickCount(ctx context.Context, shortPath string) error {
-	clickKey := fmt.Sprintf("%s%s", constants.ClickCountKeyPrefix, shortPath)
-	return s.client.Incr(ctx, clickKey).Err()
+	return s.AddClicks(ctx, map[string]*storage.ClickBatch{shortPath: {Count: 1}})
+}
+
+// AddClicks adds batches of clicks to the click counters, the current hourly and daily buckets, the
+// visitor HyperLogLogs and the breakdown hashes in a single pipeline. Every bucket is a key of its own that expires after
+// the retention of its resolution.
+func (s *RedisService) AddClicks(ctx context.Context, clicks map[string]*storage.ClickBatch) error {
+	now := time.Now()
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for shortPath, batch := range clicks {
+			pipe.IncrBy(ctx, s.clickCountKey(shortPath), batch.Count)
+			for _, resolution := range storage.Resolutions {
+				key := s.seriesKey(shortPath, resolution, now)
+				pipe.IncrBy(ctx, key, batch.Count)
+				pipe.Expire(ctx, key, resolution.Retention()+resolution.Duration())
+			}
+			if len(batch.Visitors) > 0 {
+				visitors := make([]interface{}, 0, len(batch.Visitors))
+				for visitor := range batch.Visitors {
+					visitors = append(visitors, visitor)
+				}
+				pipe.PFAdd(ctx, s.visitorsKey(shortPath), visitors...)
+			}
+			for dimension, counts := range batch.Breakdowns {
+				args := make([]interface{}, 0, 2+2*len(counts))
+				args = append(args, constants.BreakdownMaxValues, storage.BreakdownOther)
+				for value, count := range counts {
+					args = append(args, value, count)
+				}
+				breakdownScript.Eval(ctx, pipe, []string{s.breakdownKey(shortPath, dimension)}, args...)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// GetUniqueVisitors returns the HyperLogLog estimate of the distinct visitors of shortPath,
+// which is within about 1% of the exact count
+func (s *RedisService) GetUniqueVisitors(ctx context.Context, shortPath string) (int64, error) {
+	return s.client.PFCount(ctx, s.visitorsKey(shortPath)).Result()
+}
+
+// GetBreakdown reads the whole breakdown hash and ranks it. AddClicks caps the hash at
+// constants.BreakdownMaxValues values, plus one for the others.
+func (s *RedisService) GetBreakdown(ctx context.Context, shortPath string, dimension storage.Dimension, n int) ([]storage.BreakdownEntry, error) {
+	values, err := s.client.HGetAll(ctx, s.breakdownKey(shortPath, dimension)).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(values))
+	for value, count := range values {
+		counts[value], _ = strconv.ParseInt(count, 10, 64)
+	}
+	return storage.TopEntries(counts, n), nil
+}
+
+// GetClickSeries reads the last n buckets of shortPath in one MGET
+func (s *RedisService) GetClickSeries(ctx context.Context, shortPath string, resolution storage.Resolution, n int) ([]storage.ClickBucket, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	starts := resolution.Starts(now, n)
+	keys := make([]string, len(starts))
+	for i, start := range starts {
+		keys[i] = s.seriesKey(shortPath, resolution, start)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	oldest := resolution.Start(now.Add(-resolution.Retention()))
+	buckets := make([]storage.ClickBucket, len(starts))
+	for i, start := range starts {
+		buckets[i].Start = start
+		if value, ok := values[i].(string); ok && !start.Before(oldest) {
+			buckets[i].Count, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return buckets, nil
+}
+
+// WatchChanges subscribes to the invalidation channel. Messages published while the subscription
+// is being re-established are lost, so every (re)subscription is reported as an empty short path.
+func (s *RedisService) WatchChanges(ctx context.Context, onChange func(shortPath string)) error {
+	pubsub := s.client.Subscribe(ctx, constants.InvalidationChannel)
+	defer pubsub.Close() // nolint:errcheck
+
+	messages := pubsub.ChannelWithSubscriptions(ctx, 100)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			switch message := message.(type) {
+			case *redis.Subscription:
+				onChange("")
+			case *redis.Message:
+				onChange(message.Payload)
+			}
+		}
+	}
+}
+
+// publishChange tells the redirect servers to drop shortPath from their caches
+func (s *RedisService) publishChange(ctx context.Context, shortPath string) error {
+	return s.client.Publish(ctx, constants.InvalidationChannel, shortPath).Err()
+}
+
+// markExpiry keeps a marker for expiring paths that outlives the mapping itself,
+// so the redirect server can tell an expired link from an unknown one.
+func (s *RedisService) markExpiry(ctx context.Context, shortPath, targetURL string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return s.client.Del(ctx, s.expiredKey(shortPath)).Err()
+	}
+	retention := ttl + time.Duration(constants.ExpiredLinkRetention)*time.Second
+	return s.client.Set(ctx, s.expiredKey(shortPath), targetURL, retention).Err()
+}
+
+// linkKeys returns the keys the claim scripts operate on, see linkHelpers
+func (s *RedisService) linkKeys(shortPath string) []string {
+	return []string{shortPath, s.ownerKey(shortPath), s.redirectTypeKey(shortPath), s.pathMetricsKey(shortPath)}
+}
+
+// scriptArgs returns the ARGV of the claim scripts for link
+func scriptArgs(owner string, link storage.Link) []interface{} {
+	redirectType := ""
+	if link.RedirectType != 0 {
+		redirectType = strconv.Itoa(link.RedirectType)
+	}
+	pathMetrics := ""
+	if link.PathMetrics {
+		pathMetrics = "1"
+	}
+	return []interface{}{link.TargetURL, owner, link.TTL.Milliseconds(), redirectType, pathMetrics}
+}
+
+func (s *RedisService) clickCountKey(shortPath string) string {
+	return s.key(constants.ClickCountKeyPrefix, shortPath)
+}
+
+func (s *RedisService) ownerKey(shortPath string) string {
+	return s.key(constants.OwnerKeyPrefix, shortPath)
+}
+
+func (s *RedisService) expiredKey(shortPath string) string {
+	return s.key(constants.ExpiredKeyPrefix, shortPath)
+}
+
+func (s *RedisService) redirectTypeKey(shortPath string) string {
+	return s.key(constants.RedirectTypeKeyPrefix, shortPath)
+}
+
+func (s *RedisService) pathMetricsKey(shortPath string) string {
+	return s.key(constants.PathMetricsKeyPrefix, shortPath)
+}
+
+func (s *RedisService) visitorsKey(shortPath string) string {
+	return s.key(constants.VisitorsKeyPrefix, shortPath)
+}
+
+// breakdownKey returns the hash counting the clicks on shortPath by the values of dimension,
+// e.g. "breakdown:country:/abc"
+func (s *RedisService) breakdownKey(shortPath string, dimension storage.Dimension) string {
+	return s.key(constants.BreakdownKeyPrefix+string(dimension)+":", shortPath)
+}
+
+func (s *RedisService) breakdownKeys(shortPath string) []string {
+	keys := make([]string, 0, len(storage.Dimensions))
+	for _, dimension := range storage.Dimensions {
+		keys = append(keys, s.breakdownKey(shortPath, dimension))
+	}
+	return keys
+}
+
+// seriesKey returns the key of the bucket t falls into, e.g. "series:hour:/abc:2006010215".
+// Short paths never contain colons, so the bucket suffix is unambiguous.
+func (s *RedisService) seriesKey(shortPath string, resolution storage.Resolution, t time.Time) string {
+	return s.key(constants.ClickSeriesKeyPrefix+string(resolution)+":", shortPath) + ":" + resolution.Format(t)
+}
+
+// seriesKeys returns the keys of every bucket of shortPath still within retention
+func (s *RedisService) seriesKeys(shortPath string) []string {
+	now := time.Now()
+	var keys []string
+	for _, resolution := range storage.Resolutions {
+		for _, start := range resolution.Starts(now, resolution.Buckets()+1) {
+			keys = append(keys, s.seriesKey(shortPath, resolution, start))
+		}
+	}
+	return keys
+}
+
+// key derives a key of shortPath. Short paths never contain braces, so "{/abc}" hashes to the same
+// cluster slot as "/abc".
+func (s *RedisService) key(prefix, shortPath string) string {
+	if s.hashTags {
+		return fmt.Sprintf("%s{%s}", prefix, shortPath)
+	}
+	return fmt.Sprintf("%s%s", prefix, shortPath)
 }
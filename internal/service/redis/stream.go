@@ -0,0 +1,210 @@
+package redisHandler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+var _ storage.ClickEventStream = &RedisService{}
+
+// Fields of the click event stream entries
+const (
+	fieldTime      = "time"
+	fieldPath      = "path"
+	fieldNamespace = "namespace"
+	fieldName      = "name"
+	fieldReferrer  = "referrer"
+	fieldUserAgent = "user_agent"
+	fieldClientIP  = "client_ip"
+)
+
+// AppendClickEvents adds events to the click event stream in a single pipeline. The stream is
+// trimmed to CLICK_STREAM_MAX_LEN entries and CLICK_STREAM_RETENTION seconds, whichever is
+// shorter; trimming is approximate, so a few more entries may be kept.
+func (s *RedisService) AppendClickEvents(ctx context.Context, events []storage.ClickEvent) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, event := range events {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: constants.ClickStreamKey,
+				MaxLen: int64(constants.ClickStreamMaxLen),
+				Approx: true,
+				Values: []interface{}{
+					fieldTime, event.Time.UTC().Format(time.RFC3339Nano),
+					fieldPath, event.ShortPath,
+					fieldNamespace, event.Namespace,
+					fieldName, event.Name,
+					fieldReferrer, event.Referrer,
+					fieldUserAgent, event.UserAgent,
+					fieldClientIP, event.ClientIP,
+				},
+			})
+		}
+		if constants.ClickStreamRetention > 0 {
+			oldest := time.Now().Add(-time.Duration(constants.ClickStreamRetention) * time.Second)
+			pipe.XTrimMinIDApprox(ctx, constants.ClickStreamKey, strconv.FormatInt(oldest.UnixMilli(), 10), 0)
+		}
+		return nil
+	})
+	return err
+}
+
+// ClickEventConsumer reads the click event stream as a member of a consumer group. Each event is
+// delivered to one consumer of the group and acknowledged once it has been handled. Events left
+// pending by a consumer that went away are claimed by another one after ClaimIdle.
+type ClickEventConsumer struct {
+	client   redis.UniversalClient
+	group    string
+	consumer string
+
+	// Count is the maximum number of events read at once
+	Count int64
+	// Block is how long a read waits for new events
+	Block time.Duration
+	// ClaimIdle is how long events stay pending with another consumer before they are claimed
+	ClaimIdle time.Duration
+}
+
+// ClickEventConsumer returns a consumer named consumer in group. The group is created on the first
+// Consume if needed, starting with the events added from then on.
+func (s *RedisService) ClickEventConsumer(group, consumer string) *ClickEventConsumer {
+	return &ClickEventConsumer{
+		client:    s.client,
+		group:     group,
+		consumer:  consumer,
+		Count:     100,
+		Block:     5 * time.Second,
+		ClaimIdle: time.Minute,
+	}
+}
+
+// Consume calls handle with every event delivered to the consumer until ctx is done. Events
+// are acknowledged once handle returns nil. If it returns an error, Consume stops and returns it;
+// the event stays pending and is handled again by the next Consume of the same consumer, or by
+// another consumer after ClaimIdle. Entries that can't be decoded are logged and acknowledged.
+func (c *ClickEventConsumer) Consume(ctx context.Context, handle func(ctx context.Context, event storage.ClickEvent) error) error {
+	err := c.client.XGroupCreateMkStream(ctx, constants.ClickStreamKey, c.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	// Start with the events delivered to this consumer before that weren't acknowledged
+	pendingID := "0"
+	for ctx.Err() == nil {
+		var messages []redis.XMessage
+		if pendingID != "" {
+			messages, err = c.read(ctx, pendingID, -1)
+			pendingID = ""
+			if len(messages) > 0 {
+				pendingID = messages[len(messages)-1].ID
+			}
+		} else {
+			messages, err = c.claim(ctx)
+			if err == nil && len(messages) == 0 {
+				messages, err = c.read(ctx, ">", c.Block)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		if err := c.handle(ctx, messages, handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reads events starting after id, ">" for new ones. It doesn't block if block is negative.
+func (c *ClickEventConsumer) read(ctx context.Context, id string, block time.Duration) ([]redis.XMessage, error) {
+	streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.consumer,
+		Streams:  []string{constants.ClickStreamKey, id},
+		Count:    c.Count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	return streams[0].Messages, nil
+}
+
+// claim takes over events that have been pending for longer than ClaimIdle. XAUTOCLAIM would do
+// this in one call, but this client can't parse its reply from Redis 7.
+func (c *ClickEventConsumer) claim(ctx context.Context) ([]redis.XMessage, error) {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: constants.ClickStreamKey,
+		Group:  c.group,
+		Idle:   c.ClaimIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  c.Count,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	ids := make([]string, len(pending))
+	for i, entry := range pending {
+		ids[i] = entry.ID
+	}
+	return c.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   constants.ClickStreamKey,
+		Group:    c.group,
+		Consumer: c.consumer,
+		MinIdle:  c.ClaimIdle,
+		Messages: ids,
+	}).Result()
+}
+
+func (c *ClickEventConsumer) handle(ctx context.Context, messages []redis.XMessage, handle func(ctx context.Context, event storage.ClickEvent) error) error {
+	for _, message := range messages {
+		event, err := decodeClickEvent(message.Values)
+		if err != nil {
+			ctrllog.FromContext(ctx).Error(err, "Skipping malformed click event", "id", message.ID)
+		} else if err := handle(ctx, event); err != nil {
+			return err
+		}
+		// Acknowledge handled events even if ctx was cancelled meanwhile, so they aren't handled twice
+		if err := c.client.XAck(context.WithoutCancel(ctx), constants.ClickStreamKey, c.group, message.ID).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeClickEvent(values map[string]interface{}) (storage.ClickEvent, error) {
+	field := func(name string) string {
+		value, _ := values[name].(string)
+		return value
+	}
+	eventTime, err := time.Parse(time.RFC3339Nano, field(fieldTime))
+	if err != nil {
+		return storage.ClickEvent{}, err
+	}
+	return storage.ClickEvent{
+		Time:      eventTime,
+		ShortPath: field(fieldPath),
+		Namespace: field(fieldNamespace),
+		Name:      field(fieldName),
+		Referrer:  field(fieldReferrer),
+		UserAgent: field(fieldUserAgent),
+		ClientIP:  field(fieldClientIP),
+	}, nil
+}
@@ -0,0 +1,185 @@
+package redisHandler
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+	"github.com/abexamir/url-shortener-operator/internal/service/storage"
+)
+
+const testGroup = "exporter"
+
+var errHandle = errors.New("handler failed")
+
+// newStreamService returns a service backed by miniredis with the consumer group created, so
+// events appended from then on are delivered to it
+func newStreamService(t *testing.T) (*RedisService, *miniredis.Miniredis) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	service, err := NewRedisService(Options{Mode: ModeStandalone, Addrs: []string{m.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = service.client.Close() })
+	if err := service.client.XGroupCreateMkStream(context.Background(), constants.ClickStreamKey, testGroup, "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	return service, m
+}
+
+func newConsumer(service *RedisService, name string) *ClickEventConsumer {
+	consumer := service.ClickEventConsumer(testGroup, name)
+	consumer.Block = 10 * time.Millisecond
+	return consumer
+}
+
+func appendClicks(t *testing.T, service *RedisService, shortPaths ...string) {
+	t.Helper()
+	events := make([]storage.ClickEvent, len(shortPaths))
+	for i, shortPath := range shortPaths {
+		events[i] = storage.ClickEvent{
+			Time:      time.Now(),
+			ShortPath: shortPath,
+			Namespace: "default",
+			Name:      "a",
+			UserAgent: "curl/8.5.0",
+		}
+	}
+	if err := service.AppendClickEvents(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// consumeUntil runs consumer until n events have been handled successfully and returns their
+// short paths in order. failOn makes the handler fail once for the event with that short path.
+func consumeUntil(t *testing.T, consumer *ClickEventConsumer, n int, failOn string) ([]string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var handled []string
+	err := consumer.Consume(ctx, func(_ context.Context, event storage.ClickEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if event.ShortPath == failOn {
+			failOn = ""
+			return errHandle
+		}
+		handled = append(handled, event.ShortPath)
+		if len(handled) == n {
+			cancel()
+		}
+		return nil
+	})
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Fatalf("timed out after handling %v", handled)
+	}
+	return handled, err
+}
+
+func pendingCount(t *testing.T, service *RedisService) int64 {
+	t.Helper()
+	pending, err := service.client.XPending(context.Background(), constants.ClickStreamKey, testGroup).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending.Count
+}
+
+func TestClickEventConsumer(t *testing.T) {
+	service, _ := newStreamService(t)
+	appendClicks(t, service, "/abc", "/def")
+
+	handled, err := consumeUntil(t, newConsumer(service, "a"), 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(handled, []string{"/abc", "/def"}) {
+		t.Errorf("unexpected events %v", handled)
+	}
+	if pending := pendingCount(t, service); pending != 0 {
+		t.Errorf("expected the events to be acknowledged, %d are pending", pending)
+	}
+}
+
+func TestClickEventConsumerPending(t *testing.T) {
+	service, _ := newStreamService(t)
+	appendClicks(t, service, "/abc", "/def")
+	consumer := newConsumer(service, "a")
+
+	// The failed event and the ones after it stay pending
+	if _, err := consumeUntil(t, consumer, 2, "/abc"); !errors.Is(err, errHandle) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+	if pending := pendingCount(t, service); pending != 2 {
+		t.Fatalf("expected 2 pending events, got %d", pending)
+	}
+
+	// The next Consume of the same consumer reads them again before new events
+	appendClicks(t, service, "/ghi")
+	handled, err := consumeUntil(t, consumer, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(handled, []string{"/abc", "/def", "/ghi"}) {
+		t.Errorf("unexpected events %v", handled)
+	}
+	if pending := pendingCount(t, service); pending != 0 {
+		t.Errorf("expected the events to be acknowledged, %d are pending", pending)
+	}
+}
+
+func TestClickEventConsumerClaim(t *testing.T) {
+	service, _ := newStreamService(t)
+	appendClicks(t, service, "/abc")
+
+	// Consumer a goes away with the event pending
+	if _, err := consumeUntil(t, newConsumer(service, "a"), 1, "/abc"); !errors.Is(err, errHandle) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+
+	b := newConsumer(service, "b")
+	b.ClaimIdle = 50 * time.Millisecond
+	time.Sleep(2 * b.ClaimIdle)
+	handled, err := consumeUntil(t, b, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(handled, []string{"/abc"}) {
+		t.Errorf("unexpected events %v", handled)
+	}
+	if pending := pendingCount(t, service); pending != 0 {
+		t.Errorf("expected the claimed event to be acknowledged, %d are pending", pending)
+	}
+}
+
+func TestClickEventConsumerMalformed(t *testing.T) {
+	service, _ := newStreamService(t)
+	if err := service.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: constants.ClickStreamKey,
+		Values: []interface{}{fieldTime, "yesterday", fieldPath, "/bad"},
+	}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	appendClicks(t, service, "/abc")
+
+	handled, err := consumeUntil(t, newConsumer(service, "a"), 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(handled, []string{"/abc"}) {
+		t.Errorf("expected the malformed event to be skipped, got %v", handled)
+	}
+	if pending := pendingCount(t, service); pending != 0 {
+		t.Errorf("expected the malformed event to be acknowledged, %d are pending", pending)
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Dimension is a property of clicks they are broken down by
+type Dimension string
+
+const (
+	DimensionReferrer Dimension = "referrer"
+	DimensionBrowser  Dimension = "browser"
+	DimensionOS       Dimension = "os"
+	DimensionCountry  Dimension = "country"
+)
+
+// BreakdownOther is the value clicks are counted under once a dimension of a short path has
+// constants.BreakdownMaxValues distinct values, so clients can't grow it without bounds
+const BreakdownOther = "Other"
+
+// Dimensions lists every dimension clicks are broken down by
+var Dimensions = []Dimension{DimensionReferrer, DimensionBrowser, DimensionOS, DimensionCountry}
+
+// BreakdownEntry is the number of clicks with a value of a dimension
+type BreakdownEntry struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// TopEntries returns the n entries of counts with the most clicks, all of them if n isn't positive.
+// Ties are ordered by value.
+func TopEntries(counts map[string]int64, n int) []BreakdownEntry {
+	entries := make([]BreakdownEntry, 0, len(counts))
+	for value, count := range counts {
+		entries = append(entries, BreakdownEntry{Value: value, Count: count})
+	}
+	slices.SortFunc(entries, func(a, b BreakdownEntry) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Value, b.Value))
+	})
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
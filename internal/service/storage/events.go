@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// ClickEvent describes a single redirect
+type ClickEvent struct {
+	Time      time.Time `json:"time"`
+	ShortPath string    `json:"shortPath"`
+	// Namespace and Name identify the ShortURL owning the short path. Shared paths have several
+	// owners resolving to the same link, the first one in namespace/name order is reported.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// ClientIP is the client address with its host bits cleared, /24 for IPv4 and /48 for IPv6
+	ClientIP string `json:"clientIP,omitempty"`
+}
+
+// ClickEventStream is implemented by backends that keep a stream of click events for other consumers
+type ClickEventStream interface {
+	// AppendClickEvents adds events to the stream, trimming it to its configured retention
+	AppendClickEvents(ctx context.Context, events []ClickEvent) error
+}
@@ -0,0 +1,451 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+)
+
+// Kinds of claim recorded for the owners of a short path
+const (
+	claimCustom = "custom"
+	claimHash   = "hash"
+)
+
+// memoryEntry is a short path mapping together with its owners, which expire with it
+type memoryEntry struct {
+	link          Link
+	owners        map[string]string   // owner -> kind of claim
+	metricsOwners map[string]struct{} // owners that opted in to path metrics
+	expiresAt     time.Time           // zero for no expiry
+}
+
+// setPathMetrics records whether owner opted in to path metrics
+func (e *memoryEntry) setPathMetrics(owner string, pathMetrics bool) {
+	if e.metricsOwners == nil {
+		e.metricsOwners = map[string]struct{}{}
+	}
+	if pathMetrics {
+		e.metricsOwners[owner] = struct{}{}
+	} else {
+		delete(e.metricsOwners, owner)
+	}
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryStorage is a Storage kept in process memory, for local runs and tests. It behaves like
+// the Redis backend but its state is lost on restart and isn't shared between replicas.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	expired map[string]time.Time // short path -> end of the expired link retention
+	clicks  map[string]int64
+	// series holds the click buckets of each short path, keyed by bucket start
+	series   map[string]map[Resolution]map[time.Time]int64
+	visitors map[string]map[string]struct{}
+	// breakdowns holds the click counts of each short path by dimension and value
+	breakdowns map[string]map[Dimension]map[string]int64
+	now        func() time.Time
+
+	watchersMu sync.Mutex
+	watchers   map[*func(string)]struct{}
+}
+
+var (
+	_ Storage = &MemoryStorage{}
+	_ Watcher = &MemoryStorage{}
+)
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		entries:    map[string]*memoryEntry{},
+		expired:    map[string]time.Time{},
+		clicks:     map[string]int64{},
+		series:     map[string]map[Resolution]map[time.Time]int64{},
+		visitors:   map[string]map[string]struct{}{},
+		breakdowns: map[string]map[Dimension]map[string]int64{},
+		now:        time.Now,
+		watchers:   map[*func(string)]struct{}{},
+	}
+}
+
+func (s *MemoryStorage) Ping(context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) GetURL(ctx context.Context, shortPath string) (string, error) {
+	link, err := s.GetLink(ctx, shortPath)
+	return link.TargetURL, err
+}
+
+func (s *MemoryStorage) SetURL(_ context.Context, shortPath, targetURL string, ttl time.Duration) error {
+	defer s.notify(shortPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entry(shortPath)
+	if entry == nil {
+		entry = &memoryEntry{owners: map[string]string{}}
+		s.entries[shortPath] = entry
+	}
+	entry.link.TargetURL = targetURL
+	entry.expiresAt = s.expiry(ttl)
+	s.markExpiry(shortPath, ttl)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteURL(_ context.Context, shortPath string) error {
+	defer s.notify(shortPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, shortPath)
+	delete(s.expired, shortPath)
+	return nil
+}
+
+func (s *MemoryStorage) GetLink(_ context.Context, shortPath string) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entry(shortPath)
+	if entry == nil {
+		return Link{}, ErrNotFound
+	}
+	link := Link{
+		TargetURL:    entry.link.TargetURL,
+		RedirectType: entry.link.RedirectType,
+		PathMetrics:  len(entry.metricsOwners) > 0,
+		Owners:       s.sortedOwners(entry),
+	}
+	for owner := range entry.metricsOwners {
+		link.PathMetricsOwners = append(link.PathMetricsOwners, owner)
+	}
+	slices.Sort(link.PathMetricsOwners)
+	if !entry.expiresAt.IsZero() {
+		link.TTL = entry.expiresAt.Sub(s.now())
+	}
+	return link, nil
+}
+
+func (s *MemoryStorage) ShareURL(_ context.Context, shortPath, owner string, link Link) error {
+	defer s.notify(shortPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := s.expiry(link.TTL)
+	entry := s.entry(shortPath)
+	if entry == nil {
+		entry = &memoryEntry{owners: map[string]string{}}
+		s.entries[shortPath] = entry
+	} else {
+		if entry.link.TargetURL != link.TargetURL || entry.link.RedirectType != link.RedirectType {
+			return fmt.Errorf("%w: %s points to another target", ErrPathConflict, shortPath)
+		}
+		// Keep the mapping alive for as long as the longest-lived owner needs it
+		for other, kind := range entry.owners {
+			if other == owner {
+				continue
+			}
+			if kind == claimCustom {
+				return fmt.Errorf("%w: %s is owned by %s", ErrPathConflict, shortPath, other)
+			}
+			if entry.expiresAt.IsZero() || expiresAt.IsZero() {
+				expiresAt = time.Time{}
+			} else if entry.expiresAt.After(expiresAt) {
+				expiresAt = entry.expiresAt
+			}
+		}
+	}
+	entry.link = Link{TargetURL: link.TargetURL, RedirectType: link.RedirectType}
+	entry.owners[owner] = claimHash
+	entry.setPathMetrics(owner, link.PathMetrics)
+	entry.expiresAt = expiresAt
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		ttl = expiresAt.Sub(s.now())
+	}
+	s.markExpiry(shortPath, ttl)
+	return nil
+}
+
+func (s *MemoryStorage) ClaimURL(_ context.Context, shortPath, owner string, link Link) error {
+	return s.claim(shortPath, owner, link, claimCustom)
+}
+
+func (s *MemoryStorage) RetargetURL(_ context.Context, shortPath, owner string, link Link) error {
+	return s.claim(shortPath, owner, link, claimHash)
+}
+
+func (s *MemoryStorage) claim(shortPath, owner string, link Link, kind string) error {
+	defer s.notify(shortPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entry(shortPath)
+	if entry == nil {
+		entry = &memoryEntry{owners: map[string]string{}}
+		s.entries[shortPath] = entry
+	}
+	for _, other := range s.sortedOwners(entry) {
+		if other != owner {
+			return fmt.Errorf("%w: %s is owned by %s", ErrPathConflict, shortPath, other)
+		}
+	}
+	entry.link = Link{TargetURL: link.TargetURL, RedirectType: link.RedirectType}
+	entry.owners[owner] = kind
+	entry.metricsOwners = nil
+	entry.setPathMetrics(owner, link.PathMetrics)
+	entry.expiresAt = s.expiry(link.TTL)
+	s.markExpiry(shortPath, link.TTL)
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseURL(_ context.Context, shortPath, owner string) error {
+	defer s.notify(shortPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry := s.entry(shortPath); entry != nil {
+		delete(entry.owners, owner)
+		delete(entry.metricsOwners, owner)
+		if len(entry.owners) > 0 {
+			return nil
+		}
+	}
+	delete(s.entries, shortPath)
+	delete(s.expired, shortPath)
+	delete(s.clicks, shortPath)
+	delete(s.series, shortPath)
+	delete(s.visitors, shortPath)
+	delete(s.breakdowns, shortPath)
+	return nil
+}
+
+func (s *MemoryStorage) GetOwners(_ context.Context, shortPath string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entry(shortPath)
+	if entry == nil {
+		return nil, nil
+	}
+	return s.sortedOwners(entry), nil
+}
+
+func (s *MemoryStorage) ListShortPaths(_ context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var shortPaths []string
+	for shortPath := range s.entries {
+		if s.entry(shortPath) != nil {
+			shortPaths = append(shortPaths, shortPath)
+		}
+	}
+	for shortPath := range s.clicks {
+		if _, ok := s.entries[shortPath]; !ok {
+			shortPaths = append(shortPaths, shortPath)
+		}
+	}
+	slices.Sort(shortPaths)
+	return shortPaths, nil
+}
+
+func (s *MemoryStorage) PurgeURL(_ context.Context, shortPath string) error {
+	defer s.notify(shortPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, shortPath)
+	delete(s.expired, shortPath)
+	delete(s.clicks, shortPath)
+	delete(s.series, shortPath)
+	delete(s.visitors, shortPath)
+	delete(s.breakdowns, shortPath)
+	return nil
+}
+
+func (s *MemoryStorage) IsExpired(_ context.Context, shortPath string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.expired[shortPath]
+	if ok && !s.now().Before(until) {
+		delete(s.expired, shortPath)
+		return false, nil
+	}
+	return ok, nil
+}
+
+func (s *MemoryStorage) GetClickCount(_ context.Context, shortPath string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clicks[shortPath], nil
+}
+
+func (s *MemoryStorage) IncrementClickCount(_ context.Context, shortPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addClicks(shortPath, 1)
+	return nil
+}
+
+func (s *MemoryStorage) AddClicks(_ context.Context, clicks map[string]*ClickBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for shortPath, batch := range clicks {
+		s.addClicks(shortPath, batch.Count)
+		if len(batch.Visitors) > 0 && s.visitors[shortPath] == nil {
+			s.visitors[shortPath] = map[string]struct{}{}
+		}
+		for visitor := range batch.Visitors {
+			s.visitors[shortPath][visitor] = struct{}{}
+		}
+		if len(batch.Breakdowns) > 0 && s.breakdowns[shortPath] == nil {
+			s.breakdowns[shortPath] = map[Dimension]map[string]int64{}
+		}
+		for dimension, counts := range batch.Breakdowns {
+			if s.breakdowns[shortPath][dimension] == nil {
+				s.breakdowns[shortPath][dimension] = map[string]int64{}
+			}
+			breakdown := s.breakdowns[shortPath][dimension]
+			for value, count := range counts {
+				if _, ok := breakdown[value]; !ok && len(breakdown) >= constants.BreakdownMaxValues {
+					value = BreakdownOther
+				}
+				breakdown[value] += count
+			}
+		}
+	}
+	return nil
+}
+
+// GetUniqueVisitors counts visitors exactly
+func (s *MemoryStorage) GetUniqueVisitors(_ context.Context, shortPath string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.visitors[shortPath])), nil
+}
+
+func (s *MemoryStorage) GetBreakdown(_ context.Context, shortPath string, dimension Dimension, n int) ([]BreakdownEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return TopEntries(s.breakdowns[shortPath][dimension], n), nil
+}
+
+func (s *MemoryStorage) GetClickSeries(_ context.Context, shortPath string, resolution Resolution, n int) ([]ClickBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	oldest := now.Add(-resolution.Retention())
+	buckets := make([]ClickBucket, 0, n)
+	for _, start := range resolution.Starts(now, n) {
+		bucket := ClickBucket{Start: start}
+		if !start.Before(resolution.Start(oldest)) {
+			bucket.Count = s.series[shortPath][resolution][start]
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// addClicks adds count to the click counter and the current buckets of shortPath, dropping
+// buckets past their retention. Callers must hold s.mu.
+func (s *MemoryStorage) addClicks(shortPath string, count int64) {
+	s.clicks[shortPath] += count
+
+	now := s.now()
+	if s.series[shortPath] == nil {
+		s.series[shortPath] = map[Resolution]map[time.Time]int64{}
+	}
+	for _, resolution := range Resolutions {
+		buckets := s.series[shortPath][resolution]
+		if buckets == nil {
+			buckets = map[time.Time]int64{}
+			s.series[shortPath][resolution] = buckets
+		}
+		buckets[resolution.Start(now)] += count
+		oldest := resolution.Start(now.Add(-resolution.Retention()))
+		for start := range buckets {
+			if start.Before(oldest) {
+				delete(buckets, start)
+			}
+		}
+	}
+}
+
+func (s *MemoryStorage) WatchChanges(ctx context.Context, onChange func(shortPath string)) error {
+	s.watchersMu.Lock()
+	s.watchers[&onChange] = struct{}{}
+	s.watchersMu.Unlock()
+
+	<-ctx.Done()
+
+	s.watchersMu.Lock()
+	delete(s.watchers, &onChange)
+	s.watchersMu.Unlock()
+	return nil
+}
+
+// notify tells the watchers about a change of shortPath. Callers must not hold s.mu.
+func (s *MemoryStorage) notify(shortPath string) {
+	s.watchersMu.Lock()
+	defer s.watchersMu.Unlock()
+
+	for onChange := range s.watchers {
+		(*onChange)(shortPath)
+	}
+}
+
+// entry returns the live entry of shortPath, dropping it if it has expired. Callers must hold s.mu.
+func (s *MemoryStorage) entry(shortPath string) *memoryEntry {
+	entry, ok := s.entries[shortPath]
+	if !ok {
+		return nil
+	}
+	if entry.expired(s.now()) {
+		delete(s.entries, shortPath)
+		return nil
+	}
+	return entry
+}
+
+func (s *MemoryStorage) sortedOwners(entry *memoryEntry) []string {
+	owners := make([]string, 0, len(entry.owners))
+	for owner := range entry.owners {
+		owners = append(owners, owner)
+	}
+	slices.Sort(owners)
+	return owners
+}
+
+func (s *MemoryStorage) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return s.now().Add(ttl)
+}
+
+// markExpiry remembers expiring paths past their expiry, like the Redis backend does.
+// Callers must hold s.mu.
+func (s *MemoryStorage) markExpiry(shortPath string, ttl time.Duration) {
+	if ttl <= 0 {
+		delete(s.expired, shortPath)
+		return
+	}
+	s.expired[shortPath] = s.now().Add(ttl + time.Duration(constants.ExpiredLinkRetention)*time.Second)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+)
+
+// Resolution is the bucket size of a click time series
+type Resolution string
+
+const (
+	ResolutionHour Resolution = "hour"
+	ResolutionDay  Resolution = "day"
+)
+
+// Resolutions lists every resolution clicks are bucketed by
+var Resolutions = []Resolution{ResolutionHour, ResolutionDay}
+
+// ClickBucket is the number of clicks in the bucket starting at Start
+type ClickBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// Duration returns the length of a bucket
+func (r Resolution) Duration() time.Duration {
+	if r == ResolutionDay {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// Retention returns how long buckets are kept
+func (r Resolution) Retention() time.Duration {
+	if r == ResolutionDay {
+		return time.Duration(constants.ClickDailyRetention) * 24 * time.Hour
+	}
+	return time.Duration(constants.ClickHourlyRetention) * time.Hour
+}
+
+// Buckets returns the number of buckets kept
+func (r Resolution) Buckets() int {
+	return int(r.Retention() / r.Duration())
+}
+
+// Start returns the start of the UTC bucket t falls into
+func (r Resolution) Start(t time.Time) time.Time {
+	return t.UTC().Truncate(r.Duration())
+}
+
+// Format returns the identifier of the bucket t falls into, e.g. "2006010215" for hours
+func (r Resolution) Format(t time.Time) string {
+	if r == ResolutionDay {
+		return t.UTC().Format("20060102")
+	}
+	return t.UTC().Format("2006010215")
+}
+
+// Starts returns the starts of the last n buckets up to and including the one now falls into,
+// oldest first
+func (r Resolution) Starts(now time.Time, n int) []time.Time {
+	current := r.Start(now)
+	starts := make([]time.Time, n)
+	for i := range starts {
+		starts[i] = current.Add(-time.Duration(n-1-i) * r.Duration())
+	}
+	return starts
+}
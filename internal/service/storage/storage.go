@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a short path has no mapping
+	ErrNotFound = errors.New("short path not found")
+	// ErrPathConflict is returned when a short path is already owned by another ShortURL
+	ErrPathConflict = errors.New("short path is owned by another ShortURL")
+)
+
+// Link is what a short path resolves to
+type Link struct {
+	TargetURL string
+	// RedirectType is the HTTP status code to redirect with, 0 for the server default
+	RedirectType int
+	// PathMetrics labels the redirect metrics of the path with the path itself. It is kept per owner
+	// and isn't part of what owners share; GetLink sets it if any owner opted in.
+	PathMetrics bool
+	// TTL is how long the mapping lives, 0 for no expiry
+	TTL time.Duration
+	// Owners are the owners sharing the path, only set by GetLink
+	Owners []string
+	// PathMetricsOwners are the owners that opted in to PathMetrics, only set by GetLink
+	PathMetricsOwners []string
+}
+
+// ClickBatch aggregates the clicks on a short path between two writes
+type ClickBatch struct {
+	Count int64
+	// Visitors holds the fingerprints of the clients that clicked
+	Visitors map[string]struct{}
+	// Breakdowns counts the clicks by the value of each dimension
+	Breakdowns map[Dimension]map[string]int64
+}
+
+// Storage keeps the short path mappings, their owners and click counters.
+// Owners are ShortURLs identified by namespace/name.
+type Storage interface {
+	// Ping checks that the storage is reachable
+	Ping(ctx context.Context) error
+
+	// GetURL returns the target URL of shortPath, or ErrNotFound
+	GetURL(ctx context.Context, shortPath string) (string, error)
+	// SetURL maps shortPath to targetURL regardless of its owners. A ttl of zero means it never expires.
+	SetURL(ctx context.Context, shortPath, targetURL string, ttl time.Duration) error
+	// DeleteURL removes the mapping of shortPath regardless of its owners
+	DeleteURL(ctx context.Context, shortPath string) error
+
+	// GetLink returns the link of shortPath with its remaining lifetime and owners, or ErrNotFound
+	GetLink(ctx context.Context, shortPath string) (Link, error)
+	// ShareURL maps shortPath to link on behalf of owner. Several owners may share a path as long as
+	// they resolve to the same link. ErrPathConflict is returned if the path resolves to another link
+	// or is exclusively claimed.
+	ShareURL(ctx context.Context, shortPath, owner string, link Link) error
+	// ClaimURL exclusively maps shortPath to link on behalf of owner, or returns ErrPathConflict
+	// if the path belongs to someone else
+	ClaimURL(ctx context.Context, shortPath, owner string, link Link) error
+	// RetargetURL points shortPath at a new link in place while owner is its sole owner, or returns
+	// ErrPathConflict. The path stays open to owners sharing the new link.
+	RetargetURL(ctx context.Context, shortPath, owner string, link Link) error
+	// ReleaseURL drops owner from shortPath. The mapping and its click counters are deleted once
+	// no owner is left.
+	ReleaseURL(ctx context.Context, shortPath, owner string) error
+	// GetOwners returns the owners currently sharing shortPath
+	GetOwners(ctx context.Context, shortPath string) ([]string, error)
+	// ListShortPaths returns every short path storage holds anything for, be it a mapping, owners
+	// or a click counter
+	ListShortPaths(ctx context.Context) ([]string, error)
+	// PurgeURL removes everything stored for shortPath regardless of its owners, including its
+	// click counter
+	PurgeURL(ctx context.Context, shortPath string) error
+	// IsExpired reports whether shortPath used to exist but has passed its expiration time
+	IsExpired(ctx context.Context, shortPath string) (bool, error)
+
+	// GetClickCount returns the number of redirects served for shortPath
+	GetClickCount(ctx context.Context, shortPath string) (int64, error)
+	// IncrementClickCount records a redirect served for shortPath
+	IncrementClickCount(ctx context.Context, shortPath string) error
+	// AddClicks records batches of clicks, keyed by short path. Breakdown values past the first
+	// constants.BreakdownMaxValues of a short path and dimension are counted as BreakdownOther.
+	AddClicks(ctx context.Context, clicks map[string]*ClickBatch) error
+	// GetClickSeries returns the click counts of the last n buckets of shortPath up to and including
+	// the current one, oldest first. Buckets past the retention of resolution read as zero.
+	GetClickSeries(ctx context.Context, shortPath string, resolution Resolution, n int) ([]ClickBucket, error)
+	// GetUniqueVisitors returns the number of distinct visitors of shortPath, which may be an estimate
+	GetUniqueVisitors(ctx context.Context, shortPath string) (int64, error)
+	// GetBreakdown returns the n values of dimension with the most clicks on shortPath, most clicked
+	// first, or all of them if n isn't positive
+	GetBreakdown(ctx context.Context, shortPath string, dimension Dimension, n int) ([]BreakdownEntry, error)
+}
+
+// Watcher is implemented by backends that announce changes to short paths, so that caches in front
+// of them can be invalidated
+type Watcher interface {
+	// WatchChanges calls onChange with every short path whose mapping changes until ctx is cancelled.
+	// An empty short path means that changes may have been missed and anything may have changed.
+	WatchChanges(ctx context.Context, onChange func(shortPath string)) error
+}
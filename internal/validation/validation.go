@@ -0,0 +1,52 @@
+package validation
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/abexamir/url-shortener-operator/internal/constants"
+)
+
+// IsValidURL reports whether s is an absolute http or https URL with a host
+func IsValidURL(s string) bool {
+	parsed, err := url.ParseRequestURI(s)
+	if err != nil {
+		return false
+	}
+	return parsed.Host != "" && (parsed.Scheme == "http" || parsed.Scheme == "https")
+}
+
+// IsReservedPath reports whether shortPath is, or is below, one of the paths the redirect server
+// serves itself
+func IsReservedPath(shortPath string) bool {
+	shortPath = strings.ToLower(shortPath)
+	for _, reserved := range constants.ReservedPaths {
+		reserved = strings.ToLower(strings.TrimSuffix(reserved, "/"))
+		if shortPath == reserved || strings.HasPrefix(shortPath, reserved+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// HostAllowed reports whether host matches one of the patterns, either exactly
+// or as a subdomain of a "*.example.com" wildcard
+func HostAllowed(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
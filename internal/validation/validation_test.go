@@ -0,0 +1,59 @@
+package validation
+
+import "testing"
+
+func TestIsValidURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com":          true,
+		"http://example.com/path?q=1":  true,
+		"ftp://example.com":            false,
+		"https://":                     false,
+		"example.com":                  false,
+		"/relative":                    false,
+		"":                             false,
+		"javascript:alert(1)":          false,
+		"https://example.com:8443/abc": true,
+	}
+	for s, expected := range tests {
+		if valid := IsValidURL(s); valid != expected {
+			t.Errorf("IsValidURL(%q): expected %v, got %v", s, expected, valid)
+		}
+	}
+}
+
+func TestIsReservedPath(t *testing.T) {
+	tests := map[string]bool{
+		"/_api":                    true,
+		"/_API":                    true,
+		"/_api/v1/links/abc/stats": true,
+		"/_apis":                   false,
+		"/api":                     false,
+		"/campaign":                false,
+		"/":                        false,
+	}
+	for shortPath, expected := range tests {
+		if reserved := IsReservedPath(shortPath); reserved != expected {
+			t.Errorf("IsReservedPath(%q): expected %v, got %v", shortPath, expected, reserved)
+		}
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	patterns := []string{"example.com", " *.Tapsi.ir ", ""}
+	tests := map[string]bool{
+		"example.com":       true,
+		"EXAMPLE.com":       true,
+		"www.example.com":   false,
+		"tapsi.ir":          false,
+		"app.tapsi.ir":      true,
+		"a.b.tapsi.ir":      true,
+		"eviltapsi.ir":      false,
+		"tapsi.ir.evil.com": false,
+		"":                  false,
+	}
+	for host, expected := range tests {
+		if allowed := HostAllowed(host, patterns); allowed != expected {
+			t.Errorf("HostAllowed(%q): expected %v, got %v", host, expected, allowed)
+		}
+	}
+}
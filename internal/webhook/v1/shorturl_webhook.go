@@ -0,0 +1,164 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	urlshortenerv1 "github.com/abexamir/url-shortener-operator/api/v1"
+	"github.com/abexamir/url-shortener-operator/internal/validation"
+)
+
+// log is for logging in this package.
+var shorturllog = logf.Log.WithName("shorturl-resource")
+
+// SetupShortURLWebhookWithManager registers the webhook for ShortURL in the manager.
+func SetupShortURLWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&urlshortenerv1.ShortURL{}).
+		WithValidator(&ShortURLCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-urlshortener-tapsi-ir-v1-shorturl,mutating=false,failurePolicy=fail,sideEffects=None,groups=urlshortener.tapsi.ir,resources=shorturls,verbs=create;update,versions=v1,name=vshorturl-v1.kb.io,admissionReviewVersions=v1
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// ShortURLCustomValidator rejects ShortURLs with invalid targets, reserved or taken custom paths,
+// or that break the policy annotated on their namespace.
+type ShortURLCustomValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &ShortURLCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ShortURL.
+func (v *ShortURLCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	shorturl, ok := obj.(*urlshortenerv1.ShortURL)
+	if !ok {
+		return nil, fmt.Errorf("expected a ShortURL object but got %T", obj)
+	}
+	shorturllog.Info("Validation for ShortURL upon creation", "name", shorturl.GetName())
+
+	return nil, v.validateShortURL(ctx, shorturl, true)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ShortURL.
+func (v *ShortURLCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	shorturl, ok := newObj.(*urlshortenerv1.ShortURL)
+	if !ok {
+		return nil, fmt.Errorf("expected a ShortURL object for the newObj but got %T", newObj)
+	}
+	oldShorturl, ok := oldObj.(*urlshortenerv1.ShortURL)
+	if !ok {
+		return nil, fmt.Errorf("expected a ShortURL object for the oldObj but got %T", oldObj)
+	}
+	shorturllog.Info("Validation for ShortURL upon update", "name", shorturl.GetName())
+
+	// Let objects that are going away drop their finalizer even if they no longer pass validation
+	if !shorturl.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	// Only check that the custom path is free when it changes, so updates that leave it alone, like the
+	// controller adding its finalizer, aren't blocked by whoever claimed the path in the meantime. The
+	// controller reports the conflict in the status instead.
+	customPathChanged := shorturl.Spec.CustomPath != oldShorturl.Spec.CustomPath
+	return nil, v.validateShortURL(ctx, shorturl, customPathChanged)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ShortURL.
+func (v *ShortURLCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateShortURL checks shorturl against the policy of its namespace, and whether its custom path is
+// already served by another ShortURL if checkPathOwner is set
+func (v *ShortURLCustomValidator) validateShortURL(ctx context.Context, shorturl *urlshortenerv1.ShortURL, checkPathOwner bool) error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	namespace := &corev1.Namespace{}
+	if err := v.Client.Get(ctx, types.NamespacedName{Name: shorturl.Namespace}, namespace); err != nil {
+		return apierrors.NewInternalError(fmt.Errorf("failed to get namespace %s: %w", shorturl.Namespace, err))
+	}
+
+	targetPath := specPath.Child("targetURL")
+	if !validation.IsValidURL(shorturl.Spec.TargetURL) {
+		allErrs = append(allErrs, field.Invalid(targetPath, shorturl.Spec.TargetURL,
+			"must be an absolute http or https URL"))
+	} else if allowed, ok := namespace.Annotations[urlshortenerv1.AnnotationAllowedTargetHosts]; ok {
+		parsed, _ := url.Parse(shorturl.Spec.TargetURL)
+		if !validation.HostAllowed(parsed.Hostname(), strings.Split(allowed, ",")) {
+			allErrs = append(allErrs, field.Forbidden(targetPath,
+				fmt.Sprintf("host %q is not allowed in namespace %s (allowed: %s)", parsed.Hostname(), shorturl.Namespace, allowed)))
+		}
+	}
+
+	if customPath := shorturl.Spec.CustomPath; customPath != "" {
+		customPathField := specPath.Child("customPath")
+		switch {
+		case namespace.Annotations[urlshortenerv1.AnnotationAllowCustomPaths] == "false":
+			allErrs = append(allErrs, field.Forbidden(customPathField,
+				fmt.Sprintf("custom paths are not allowed in namespace %s", shorturl.Namespace)))
+		case validation.IsReservedPath(customPath):
+			allErrs = append(allErrs, field.Invalid(customPathField, customPath, "path is reserved"))
+		case checkPathOwner:
+			owner, err := v.pathOwner(ctx, shorturl, customPath)
+			if err != nil {
+				return apierrors.NewInternalError(err)
+			}
+			if owner != "" {
+				allErrs = append(allErrs, field.Duplicate(customPathField, fmt.Sprintf("%s (used by %s)", customPath, owner)))
+			}
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(urlshortenerv1.GroupVersion.WithKind("ShortURL").GroupKind(), shorturl.Name, allErrs)
+}
+
+// pathOwner returns the namespace/name of another ShortURL serving shortPath, if any. ShortURLs that only
+// request the path in their spec don't count, they may have lost it to the owner.
+func (v *ShortURLCustomValidator) pathOwner(ctx context.Context, shorturl *urlshortenerv1.ShortURL, shortPath string) (string, error) {
+	list := &urlshortenerv1.ShortURLList{}
+	if err := v.Client.List(ctx, list); err != nil {
+		return "", fmt.Errorf("failed to list ShortURLs: %w", err)
+	}
+	for _, other := range list.Items {
+		if other.Namespace == shorturl.Namespace && other.Name == shorturl.Name {
+			continue
+		}
+		if other.Status.ShortPath == shortPath {
+			return fmt.Sprintf("%s/%s", other.Namespace, other.Name), nil
+		}
+	}
+	return "", nil
+}
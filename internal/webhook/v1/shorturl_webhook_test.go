@@ -0,0 +1,169 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	urlshortenerv1 "github.com/abexamir/url-shortener-operator/api/v1"
+)
+
+const testTarget = "https://example.com/campaign"
+
+func newValidator(t *testing.T, objects ...client.Object) *ShortURLCustomValidator {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := urlshortenerv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &ShortURLCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()}
+}
+
+func newNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func newShortURL(namespace, name string, spec urlshortenerv1.ShortURLSpec) *urlshortenerv1.ShortURL {
+	return &urlshortenerv1.ShortURL{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Spec: spec}
+}
+
+// servedShortURL is a ShortURL in the default namespace that has been reconciled to shortPath
+func servedShortURL(name, shortPath string) *urlshortenerv1.ShortURL {
+	shortURL := newShortURL("default", name, urlshortenerv1.ShortURLSpec{TargetURL: testTarget, CustomPath: shortPath})
+	shortURL.Status.ShortPath = shortPath
+	return shortURL
+}
+
+func TestValidateCreate(t *testing.T) {
+	validator := newValidator(t,
+		newNamespace("default", nil),
+		newNamespace("restricted", map[string]string{
+			urlshortenerv1.AnnotationAllowedTargetHosts: "example.com, *.tapsi.ir",
+			urlshortenerv1.AnnotationAllowCustomPaths:   "false",
+		}),
+		servedShortURL("taken", "/taken"),
+	)
+
+	tests := []struct {
+		name      string
+		namespace string
+		spec      urlshortenerv1.ShortURLSpec
+		valid     bool
+	}{
+		{name: "valid", namespace: "default", spec: urlshortenerv1.ShortURLSpec{TargetURL: testTarget}, valid: true},
+		{name: "custom path", namespace: "default",
+			spec: urlshortenerv1.ShortURLSpec{TargetURL: testTarget, CustomPath: "/free"}, valid: true},
+		{name: "invalid target", namespace: "default", spec: urlshortenerv1.ShortURLSpec{TargetURL: "ftp://example.com"}},
+		{name: "reserved path", namespace: "default",
+			spec: urlshortenerv1.ShortURLSpec{TargetURL: testTarget, CustomPath: "/_api"}},
+		{name: "taken path", namespace: "default",
+			spec: urlshortenerv1.ShortURLSpec{TargetURL: testTarget, CustomPath: "/taken"}},
+		{name: "allowed host", namespace: "restricted",
+			spec: urlshortenerv1.ShortURLSpec{TargetURL: "https://app.tapsi.ir/ride"}, valid: true},
+		{name: "disallowed host", namespace: "restricted",
+			spec: urlshortenerv1.ShortURLSpec{TargetURL: "https://evil.com/"}},
+		{name: "custom paths forbidden", namespace: "restricted",
+			spec: urlshortenerv1.ShortURLSpec{TargetURL: testTarget, CustomPath: "/free"}},
+		{name: "missing namespace", namespace: "missing", spec: urlshortenerv1.ShortURLSpec{TargetURL: testTarget}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.ValidateCreate(context.Background(), newShortURL(tt.namespace, "new", tt.spec))
+			if tt.valid && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidateCreateErrorKind(t *testing.T) {
+	validator := newValidator(t, newNamespace("default", nil))
+
+	_, err := validator.ValidateCreate(context.Background(),
+		newShortURL("default", "new", urlshortenerv1.ShortURLSpec{TargetURL: "not a url"}))
+	if !apierrors.IsInvalid(err) {
+		t.Errorf("expected an invalid error for a bad spec, got %v", err)
+	}
+	_, err = validator.ValidateCreate(context.Background(),
+		newShortURL("missing", "new", urlshortenerv1.ShortURLSpec{TargetURL: testTarget}))
+	if !apierrors.IsInternalError(err) {
+		t.Errorf("expected an internal error for a missing namespace, got %v", err)
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	// "lost" requests /taken but it is served by "taken"
+	lost := newShortURL("default", "lost", urlshortenerv1.ShortURLSpec{TargetURL: testTarget, CustomPath: "/taken"})
+	validator := newValidator(t, newNamespace("default", nil), servedShortURL("taken", "/taken"), lost)
+
+	tests := []struct {
+		name   string
+		change func(shortURL *urlshortenerv1.ShortURL)
+		valid  bool
+	}{
+		{
+			name: "unchanged taken path",
+			change: func(shortURL *urlshortenerv1.ShortURL) {
+				shortURL.Finalizers = append(shortURL.Finalizers, "urlshortener.tapsi.ir/finalizer")
+			},
+			valid: true,
+		},
+		{
+			name:   "custom path changed to a free one",
+			change: func(shortURL *urlshortenerv1.ShortURL) { shortURL.Spec.CustomPath = "/free" },
+			valid:  true,
+		},
+		{
+			name:   "custom path changed to a reserved one",
+			change: func(shortURL *urlshortenerv1.ShortURL) { shortURL.Spec.CustomPath = "/_api/v1" },
+		},
+		{
+			name:   "target changed to an invalid one",
+			change: func(shortURL *urlshortenerv1.ShortURL) { shortURL.Spec.TargetURL = "example.com" },
+		},
+		{
+			name: "deleting",
+			change: func(shortURL *urlshortenerv1.ShortURL) {
+				now := metav1.Now()
+				shortURL.DeletionTimestamp = &now
+				shortURL.Spec.TargetURL = "example.com"
+				shortURL.Finalizers = nil
+			},
+			valid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := lost.DeepCopy()
+			tt.change(updated)
+			_, err := validator.ValidateUpdate(context.Background(), lost, updated)
+			if tt.valid && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	// Changing to a path another ShortURL serves is rejected
+	other := newShortURL("default", "other", urlshortenerv1.ShortURLSpec{TargetURL: testTarget, CustomPath: "/other"})
+	updated := other.DeepCopy()
+	updated.Spec.CustomPath = "/taken"
+	if _, err := validator.ValidateUpdate(context.Background(), other, updated); err == nil {
+		t.Error("expected an error when changing to a taken path")
+	}
+}